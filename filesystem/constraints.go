@@ -0,0 +1,176 @@
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/goggle/flatten/osabstraction"
+)
+
+// ErrNoSpace is returned by Constrained if a copied file does not fit
+// into the remaining free space.
+var ErrNoSpace = errors.New("no space left on device")
+
+// Permission bits, which are checked by Constrained:
+const (
+	permRead    = 4
+	permWrite   = 2
+	permExecute = 1
+)
+
+// Simulated is a simulated filesystem, which provides the metadata
+// of its files.
+type Simulated interface {
+	osabstraction.OSWrapper
+	// Lookup returns the file at path p, and false if it does not exist.
+	Lookup(p string) (DummyFile, bool)
+}
+
+// Constrained wraps a simulated filesystem and additionally models the
+// permissions of the files and the free space of a device, so that the
+// failures of a process on the real filesystem can be predicted. Only
+// the files providing their metadata (see DummyFile.HasMetadata) are
+// checked for permissions.
+type Constrained struct {
+	Simulated
+	// UID and GIDs identify the simulated user. The user with UID 0
+	// has all the permissions, a negative UID disables the checks.
+	UID  int
+	GIDs []int
+	// Free is the number of bytes, which can still be written into
+	// the Volume directory. A negative value means no limit. Copying
+	// a file into Volume consumes its size, whereas moving it does not,
+	// since only its directory entry changes.
+	Free   int64
+	Volume string
+}
+
+// NewConstrained returns a constrained filesystem based on fs, which
+// acts as the current user and which can store as many bytes in the
+// directory volume as the device containing it on the real filesystem.
+func NewConstrained(fs Simulated, volume string) (*Constrained, error) {
+	free, err := freeSpace(volume)
+	if err != nil {
+		return nil, err
+	}
+	gids, err := os.Getgroups()
+	if err != nil {
+		gids = nil
+	}
+	return &Constrained{
+		Simulated: fs,
+		UID:       os.Getuid(),
+		GIDs:      append(gids, os.Getgid()),
+		Free:      free,
+		Volume:    filepath.Clean(volume),
+	}, nil
+}
+
+// allowed returns true if the simulated user has the permissions perm
+// (a combination of permRead, permWrite and permExecute) for the file p.
+func (c *Constrained) allowed(p string, perm os.FileMode) bool {
+	df, exists := c.Lookup(p)
+	if !exists || !df.HasMetadata || c.UID <= 0 {
+		return true
+	}
+	mode := df.FileMode.Perm()
+	switch {
+	case df.UID == c.UID:
+		mode >>= 6
+	case c.inGroup(df.GID):
+		mode >>= 3
+	}
+	return mode&perm == perm
+}
+
+// inGroup returns true if the simulated user is a member of the group gid.
+func (c *Constrained) inGroup(gid int) bool {
+	for _, g := range c.GIDs {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns a permission error for the operation op on the path p,
+// if the simulated user does not have the permissions perm for dir.
+func (c *Constrained) check(op, p, dir string, perm os.FileMode) error {
+	if !c.allowed(dir, perm) {
+		return &os.PathError{Op: op, Path: p, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+// inVolume returns true if p is located in the Volume directory.
+func (c *Constrained) inVolume(p string) bool {
+	return p == c.Volume || inSubtree(p, c.Volume)
+}
+
+// Copy copies a file from source to destination, if the simulated user
+// may read it and write into the destination directory, and if there is
+// enough free space.
+func (c *Constrained) Copy(source string, destination string) error {
+	source, destination = filepath.Clean(source), filepath.Clean(destination)
+	err := c.check("open", source, source, permRead)
+	if err == nil {
+		err = c.check("open", source, filepath.Dir(source), permExecute)
+	}
+	if err == nil {
+		err = c.check("open", destination, filepath.Dir(destination), permWrite|permExecute)
+	}
+	if err != nil {
+		return err
+	}
+	df, _ := c.Lookup(source)
+	size := df.Size()
+	limited := c.Free >= 0 && c.inVolume(destination)
+	if limited && size > c.Free {
+		return &os.PathError{Op: "write", Path: destination, Err: ErrNoSpace}
+	}
+	err = c.Simulated.Copy(source, destination)
+	if err != nil {
+		return err
+	}
+	if limited {
+		c.Free -= size
+	}
+	return nil
+}
+
+// Move moves a file from source to destination, if the simulated user
+// may write into both the source and the destination directory.
+func (c *Constrained) Move(source string, destination string) error {
+	source, destination = filepath.Clean(source), filepath.Clean(destination)
+	err := c.check("rename", source, filepath.Dir(source), permWrite|permExecute)
+	if err == nil {
+		err = c.check("rename", destination, filepath.Dir(destination), permWrite|permExecute)
+	}
+	if err != nil {
+		return err
+	}
+	return c.Simulated.Move(source, destination)
+}
+
+// List returns the files and the directories located directly in dir
+// sorted by their names.
+func (c *Constrained) List(dir string) ([]osabstraction.FileInfo, error) {
+	return osabstraction.List(c.Simulated, dir)
+}
+
+// RemoveSubDirectories removes all the directories in the p subtree,
+// if the simulated user may remove all of them.
+func (c *Constrained) RemoveSubDirectories(p string) error {
+	dirs, err := c.Simulated.GetDirectories(p)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		err := c.check("remove", d.FullPath(), d.Directory(), permWrite|permExecute)
+		if err != nil {
+			return err
+		}
+	}
+	return c.Simulated.RemoveSubDirectories(p)
+}
@@ -0,0 +1,108 @@
+package filesystem
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// Diff describes the differences between two filesystems.
+type Diff struct {
+	// Added contains the files, which only exist in the new filesystem.
+	Added []DummyFile
+	// Removed contains the files, which only exist in the old filesystem.
+	Removed []DummyFile
+	// Changed contains the files, which exist in both filesystems, but
+	// differ from each other.
+	Changed []Change
+}
+
+// Change describes how a file differs between two filesystems.
+type Change struct {
+	Old, New DummyFile
+	// Fields names the properties, which differ: "type", "size", "mode",
+	// "mtime", "owner" and "content".
+	Fields []string
+}
+
+// Diff compares the filesystem fs with the newer filesystem other. All
+// the lists are sorted by the paths of the files. Like for
+// EqualWithMetadata, the metadata is only compared if it is known in
+// both filesystems, and the contents of two files are compared if they
+// are known, and their hashes otherwise.
+func (fs Filesystem) Diff(other Filesystem) Diff {
+	d := Diff{Added: []DummyFile{}, Removed: []DummyFile{}, Changed: []Change{}}
+	for p, df := range fs {
+		otherFile, exists := other[p]
+		if !exists {
+			d.Removed = append(d.Removed, df)
+			continue
+		}
+		fields := df.diffFields(otherFile)
+		if len(fields) > 0 {
+			d.Changed = append(d.Changed, Change{Old: df, New: otherFile, Fields: fields})
+		}
+	}
+	for p, df := range other {
+		if _, exists := fs[p]; !exists {
+			d.Added = append(d.Added, df)
+		}
+	}
+	sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].Path < d.Added[j].Path })
+	sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i].Path < d.Removed[j].Path })
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].Old.Path < d.Changed[j].Old.Path })
+	return d
+}
+
+// diffFields returns the names of the properties, in which df and f
+// differ.
+func (df DummyFile) diffFields(f DummyFile) []string {
+	fields := []string{}
+	if df.IsDir() != f.IsDir() {
+		return append(fields, "type")
+	}
+	if df.HasMetadata && f.HasMetadata {
+		if df.FileSize != f.FileSize {
+			fields = append(fields, "size")
+		}
+		if df.FileMode != f.FileMode {
+			fields = append(fields, "mode")
+		}
+		if !df.ModTime.Equal(f.ModTime) {
+			fields = append(fields, "mtime")
+		}
+		if df.UID != f.UID || df.GID != f.GID {
+			fields = append(fields, "owner")
+		}
+	}
+	if df.Content != nil && f.Content != nil {
+		if !bytes.Equal(df.Content, f.Content) {
+			fields = append(fields, "content")
+		}
+	} else if df.Hash != "" && f.Hash != "" && df.Hash != f.Hash {
+		fields = append(fields, "content")
+	}
+	return fields
+}
+
+// Empty returns true if there are no differences.
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns the differences with one file per line in the style
+// of a unified diff: added files are prefixed by "+", removed ones by
+// "-" and changed ones by "~", followed by the changed properties.
+func (d Diff) String() string {
+	var b strings.Builder
+	for _, df := range d.Removed {
+		b.WriteString("- " + df.FullPath() + "\n")
+	}
+	for _, df := range d.Added {
+		b.WriteString("+ " + df.FullPath() + "\n")
+	}
+	for _, c := range d.Changed {
+		b.WriteString("~ " + c.New.FullPath() + " (" + strings.Join(c.Fields, ", ") + ")\n")
+	}
+	return b.String()
+}
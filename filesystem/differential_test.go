@@ -0,0 +1,166 @@
+package filesystem_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/goggle/flatten/filesystem"
+	"github.com/goggle/flatten/osabstraction"
+)
+
+// differentialTree contains names, which share prefixes with their
+// siblings, so that a subtree query matching by string prefixes instead
+// of path components picks up the wrong files.
+var differentialTree = []string{
+	"a/",
+	"a/x.txt",
+	"a/b/",
+	"a/b/y.txt",
+	"a/b/c/",
+	"a/b/c/z.txt",
+	"ab/",
+	"ab/x.txt",
+	"ab/b/",
+	"ab/b/y.txt",
+	"a-b/",
+	"a-b/x.txt",
+	"a.b/",
+	"a b/",
+	"a b/x.txt",
+	"a.txt",
+	"empty/",
+	"empty/nested/",
+	"emptyish/",
+	"emptyish/file",
+}
+
+// newDifferential creates the differential tree in a temporary directory
+// and returns its root together with a simulated copy of it.
+func newDifferential(t *testing.T) (string, filesystem.Filesystem) {
+	root := t.TempDir()
+	for _, p := range differentialTree {
+		var err error
+		if p[len(p)-1] == '/' {
+			err = os.Mkdir(filepath.Join(root, p), 0755)
+		} else {
+			err = os.WriteFile(filepath.Join(root, p), []byte(p), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := make(filesystem.Filesystem)
+	err := fs.AddFromRealFilesystem(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root, fs
+}
+
+func paths(fis []osabstraction.FileInfo) []string {
+	ps := []string{}
+	for _, fi := range fis {
+		ps = append(ps, fi.FullPath())
+	}
+	return ps
+}
+
+// compareDifferential checks that all the queries on the simulated
+// filesystems return the same results as on the real filesystem.
+func compareDifferential(t *testing.T, root string, simulated map[string]osabstraction.OSWrapper) {
+	t.Helper()
+	real := osabstraction.RealOS{}
+	queried := []string{root, filepath.Join(root, "missing"), filepath.Join(root, "a", "missing")}
+	for _, p := range differentialTree {
+		p = filepath.Join(root, p)
+		queried = append(queried, p, p+"b", p+"-")
+	}
+	for name, sim := range simulated {
+		for _, p := range queried {
+			if sim.Exists(p) != real.Exists(p) {
+				t.Errorf("%v: Exists(%v): Expected %v", name, p, real.Exists(p))
+			}
+			if sim.IsDirectory(p) != real.IsDirectory(p) {
+				t.Errorf("%v: IsDirectory(%v): Expected %v", name, p, real.IsDirectory(p))
+			}
+			if sim.IsRegularFile(p) != real.IsRegularFile(p) {
+				t.Errorf("%v: IsRegularFile(%v): Expected %v", name, p, real.IsRegularFile(p))
+			}
+			if !real.IsDirectory(p) {
+				continue
+			}
+			for _, includeBaseFiles := range []bool{true, false} {
+				expected, err := real.GetFiles(p, includeBaseFiles)
+				noErrorExpected(t, err)
+				result, err := sim.GetFiles(p, includeBaseFiles)
+				noErrorExpected(t, err)
+				if !reflect.DeepEqual(paths(result), paths(expected)) {
+					t.Errorf("%v: GetFiles(%v, %v): Expected %v, got %v", name, p, includeBaseFiles, paths(expected), paths(result))
+				}
+			}
+			expected, err := real.GetDirectories(p)
+			noErrorExpected(t, err)
+			result, err := sim.GetDirectories(p)
+			noErrorExpected(t, err)
+			if !reflect.DeepEqual(paths(result), paths(expected)) {
+				t.Errorf("%v: GetDirectories(%v): Expected %v, got %v", name, p, paths(expected), paths(result))
+			}
+		}
+		for _, p := range queried[1:3] {
+			if _, err := sim.GetFiles(p, true); err == nil {
+				t.Errorf("%v: GetFiles(%v): Expected an error", name, p)
+			}
+			if _, err := sim.GetDirectories(p); err == nil {
+				t.Errorf("%v: GetDirectories(%v): Expected an error", name, p)
+			}
+		}
+	}
+}
+
+func TestDifferential(t *testing.T) {
+	root, fs := newDifferential(t)
+	compareDifferential(t, root, map[string]osabstraction.OSWrapper{
+		"Filesystem": fs,
+		"Overlay":    filesystem.NewOverlay(),
+	})
+}
+
+// TestDifferentialOperations applies the same operations to the real and
+// the simulated filesystem. The Overlay is not covered, since it reads
+// through to the real filesystem, which is modified here.
+func TestDifferentialOperations(t *testing.T) {
+	root, fs := newDifferential(t)
+	simulated := map[string]osabstraction.OSWrapper{"Filesystem": fs}
+	all := map[string]osabstraction.OSWrapper{"RealOS": osabstraction.RealOS{}, "Filesystem": fs}
+	operations := []struct {
+		name string
+		do   func(w osabstraction.OSWrapper) error
+	}{
+		{"Move", func(w osabstraction.OSWrapper) error {
+			return w.Move(filepath.Join(root, "ab/b/y.txt"), filepath.Join(root, "a/y.txt"))
+		}},
+		{"Copy", func(w osabstraction.OSWrapper) error {
+			return w.Copy(filepath.Join(root, "a/b/c/z.txt"), filepath.Join(root, "ab/z.txt"))
+		}},
+		{"Move", func(w osabstraction.OSWrapper) error {
+			return w.Move(filepath.Join(root, "a/b"), filepath.Join(root, "ab/b/a"))
+		}},
+		{"RemoveSubDirectories", func(w osabstraction.OSWrapper) error {
+			return w.RemoveSubDirectories(filepath.Join(root, "a-b"))
+		}},
+		{"RemoveSubDirectories", func(w osabstraction.OSWrapper) error {
+			return w.RemoveSubDirectories(filepath.Join(root, "empty"))
+		}},
+	}
+	for _, op := range operations {
+		for name, w := range all {
+			err := op.do(w)
+			if err != nil {
+				t.Fatalf("%v: %v: %v", name, op.name, err)
+			}
+		}
+		compareDifferential(t, root, simulated)
+	}
+}
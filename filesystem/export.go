@@ -0,0 +1,233 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format is a format, in which a tree can be exported.
+type Format string
+
+// The supported export formats:
+const (
+	// FormatText is the box-drawing format of the tree command.
+	FormatText Format = "text"
+	// FormatJSON is the JSON format of the tree command (tree -J).
+	FormatJSON Format = "json"
+	// FormatHTML is a self-contained HTML page with collapsible
+	// directories.
+	FormatHTML Format = "html"
+	// FormatMarkdown is a Markdown nested list.
+	FormatMarkdown Format = "markdown"
+	// FormatDOT is a Graphviz DOT graph.
+	FormatDOT Format = "dot"
+)
+
+// Formats contains all the supported export formats.
+var Formats = []Format{FormatText, FormatJSON, FormatHTML, FormatMarkdown, FormatDOT}
+
+// Export writes the tree in the given format to w. The elements are
+// restricted according to opts in the same way as for Render. Colors
+// are only used by the text format and the annotations are not part
+// of the JSON format.
+func (t Tree) Export(w io.Writer, format Format, opts RenderOptions) error {
+	if t.node == nil {
+		return errors.New("tree has not been initialized")
+	}
+	var err error
+	switch format {
+	case FormatText:
+		_, err = io.WriteString(w, t.Render(opts))
+	case FormatJSON:
+		err = t.exportJSON(w, opts)
+	case FormatHTML:
+		_, err = io.WriteString(w, t.exportHTML(opts))
+	case FormatMarkdown:
+		_, err = io.WriteString(w, t.exportMarkdown(opts))
+	case FormatDOT:
+		_, err = io.WriteString(w, t.exportDOT(opts))
+	default:
+		err = errors.New("unknown tree format: " + string(format))
+	}
+	return err
+}
+
+// jsonReport is the last element of the JSON output of the tree command.
+type jsonReport struct {
+	Type        string `json:"type"`
+	Directories int    `json:"directories"`
+	Files       int    `json:"files"`
+}
+
+// exportJSON writes the tree in the same JSON format as `tree -J`:
+// An array containing the root element and a report with the
+// number of directories and files.
+func (t *Tree) exportJSON(w io.Writer, opts RenderOptions) error {
+	report := jsonReport{Type: "report"}
+	var convert func(t *Tree, name string, depth int) jsonTree
+	convert = func(t *Tree, name string, depth int) jsonTree {
+		jt := jsonTree{Type: "file", Name: name}
+		if t.node.IsDir() {
+			jt.Type = "directory"
+		}
+		children, _ := t.renderedChildren(opts, depth)
+		for _, child := range children {
+			if child.node.IsDir() {
+				report.Directories++
+			} else {
+				report.Files++
+			}
+			jt.Contents = append(jt.Contents, convert(child, child.node.Name(), depth+1))
+		}
+		return jt
+	}
+	root := convert(t, t.node.FullPath(), 1)
+	return json.NewEncoder(w).Encode([]interface{}{root, report})
+}
+
+// statusClasses are the CSS classes of the elements in the HTML export.
+var statusClasses = map[Status]string{
+	StatusNew:     "new",
+	StatusRenamed: "renamed",
+	StatusRemoved: "removed",
+}
+
+const htmlHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%v</title>
+<style>
+body { font-family: monospace; }
+ul { list-style: none; padding-left: 1.5em; margin: 0; }
+summary { cursor: pointer; font-weight: bold; }
+.details { color: #777; font-weight: normal; }
+.new { color: #080; }
+.renamed { color: #a60; }
+.removed { color: #c00; text-decoration: line-through; }
+.collapsed { color: #777; font-style: italic; }
+</style>
+</head>
+<body>
+`
+
+const htmlFooter = `</body>
+</html>
+`
+
+// exportHTML returns a self-contained HTML page, in which every
+// directory can be collapsed and expanded.
+func (t *Tree) exportHTML(opts RenderOptions) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, htmlHeader, html.EscapeString(t.node.FullPath()))
+
+	var convert func(t *Tree, name string, depth int)
+	convert = func(t *Tree, name string, depth int) {
+		class := ""
+		if c, ok := statusClasses[t.decoration(opts).Status]; ok {
+			class = ` class="` + c + `"`
+		}
+		label := html.EscapeString(name)
+		if details := t.details(opts); details != "" {
+			label += ` <span class="details">` + html.EscapeString(strings.TrimSpace(details)) + `</span>`
+		}
+		if !t.node.IsDir() {
+			sb.WriteString("<li" + class + ">" + label + "</li>\n")
+			return
+		}
+		sb.WriteString("<li" + class + "><details open><summary>" + label + "</summary>\n<ul>\n")
+		children, hidden := t.renderedChildren(opts, depth)
+		for _, child := range children {
+			convert(child, child.node.Name(), depth+1)
+		}
+		if len(hidden) > 0 {
+			sb.WriteString(`<li class="collapsed">` + html.EscapeString(collapsedLabel(hidden)) + "</li>\n")
+		}
+		sb.WriteString("</ul>\n</details></li>\n")
+	}
+
+	sb.WriteString("<ul>\n")
+	convert(t, t.node.FullPath(), 1)
+	sb.WriteString("</ul>\n")
+	sb.WriteString(htmlFooter)
+	return sb.String()
+}
+
+// markdownEscaper escapes the characters, which have a special
+// meaning in Markdown.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`,
+)
+
+// exportMarkdown returns the tree as a Markdown nested list, in
+// which the directories are printed in bold.
+func (t *Tree) exportMarkdown(opts RenderOptions) string {
+	var sb strings.Builder
+	var convert func(t *Tree, name string, depth int)
+	convert = func(t *Tree, name string, depth int) {
+		indent := strings.Repeat("  ", depth-1)
+		label := markdownEscaper.Replace(name)
+		if t.node.IsDir() {
+			label = "**" + label + "/**"
+		}
+		sb.WriteString(indent + "- " + label + markdownEscaper.Replace(t.details(opts)) + "\n")
+		children, hidden := t.renderedChildren(opts, depth)
+		for _, child := range children {
+			convert(child, child.node.Name(), depth+1)
+		}
+		if len(hidden) > 0 {
+			sb.WriteString(indent + "  - *" + collapsedLabel(hidden) + "*\n")
+		}
+	}
+	convert(t, t.node.FullPath(), 1)
+	return sb.String()
+}
+
+// dotEscaper escapes the characters, which end or escape a quoted
+// string in DOT. All the other characters are kept as they are, since
+// DOT is read as UTF-8.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// dotQuote returns s as a quoted string in DOT.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// exportDOT returns the tree as a Graphviz DOT graph, in which every
+// element is a node with an edge from its parent directory.
+func (t *Tree) exportDOT(opts RenderOptions) string {
+	var sb strings.Builder
+	sb.WriteString("digraph tree {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	id := 0
+	var convert func(t *Tree, name string, depth int) string
+	convert = func(t *Tree, name string, depth int) string {
+		nodeID := "n" + strconv.Itoa(id)
+		id++
+		shape := "note"
+		if t.node.IsDir() {
+			shape = "folder"
+		}
+		fmt.Fprintf(&sb, "\t%v [label=%v, shape=%v];\n", nodeID, dotQuote(name+t.details(opts)), shape)
+		children, hidden := t.renderedChildren(opts, depth)
+		for _, child := range children {
+			childID := convert(child, child.node.Name(), depth+1)
+			fmt.Fprintf(&sb, "\t%v -> %v;\n", nodeID, childID)
+		}
+		if len(hidden) > 0 {
+			hiddenID := "n" + strconv.Itoa(id)
+			id++
+			fmt.Fprintf(&sb, "\t%v [label=%v, shape=plaintext];\n", hiddenID, dotQuote(collapsedLabel(hidden)))
+			fmt.Fprintf(&sb, "\t%v -> %v;\n", nodeID, hiddenID)
+		}
+		return nodeID
+	}
+	convert(t, t.node.FullPath(), 1)
+	sb.WriteString("}\n")
+	return sb.String()
+}
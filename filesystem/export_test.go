@@ -0,0 +1,5 @@
+package filesystem
+
+// SyntheticFilesystem makes syntheticFilesystem available to the
+// external tests of the package.
+var SyntheticFilesystem = syntheticFilesystem
@@ -1,17 +1,28 @@
 package filesystem
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/goggle/flatten/osabstraction"
 )
 
 // Filesystem is the data type for a fake filesystem in the memory.
+// The methods keep an index of the entries of every directory, so that
+// the queries on a subtree scale with its size. Entries can also be
+// written to or deleted from the map directly, as long as their
+// directories exist. Since the index is only rebuilt, if the number of
+// entries has changed, entries must not be added and deleted directly
+// between two calls of the methods though.
 type Filesystem map[string]DummyFile
 
 // Init initializes the filesystem by setting a root entry.
@@ -30,6 +41,10 @@ func (fs Filesystem) MkDir(dir string) error {
 	if alreadyExists {
 		return errors.New(dir + " already exists in filesystem")
 	}
+	if cleanPath == "/" {
+		fs.Init()
+		return nil
+	}
 	chain := strings.Split(cleanPath, "/")
 	currentPath := "/"
 	for _, name := range chain {
@@ -53,7 +68,7 @@ func (fs Filesystem) MkDir(dir string) error {
 		currentPath += name
 		_, exists := fs[currentPath]
 		if !exists {
-			fs[currentPath] = DummyFile{Path: currentPath, IsDirectory: true}
+			fs.set(currentPath, DummyFile{Path: currentPath, IsDirectory: true})
 		}
 		currentPath += "/"
 	}
@@ -81,7 +96,7 @@ func (fs Filesystem) CreateFile(fpath string) error {
 	if exists {
 		return errors.New(cleanPath + " already exists in file system")
 	}
-	fs[cleanPath] = file
+	fs.set(cleanPath, file)
 	return nil
 }
 
@@ -97,12 +112,10 @@ func (fs Filesystem) RemoveDirectory(path string) error {
 	} else if !entry.IsDir() {
 		return errors.New(cleanPath + " is not a directory")
 	}
-	for _, v := range fs {
-		if v.Directory() == cleanPath {
-			return errors.New(cleanPath + " is not empty!")
-		}
+	if !fs.isEmpty(cleanPath) {
+		return errors.New(cleanPath + " is not empty!")
 	}
-	delete(fs, cleanPath)
+	fs.remove(cleanPath)
 	return nil
 }
 
@@ -115,35 +128,121 @@ func (fs Filesystem) RemoveFile(path string) error {
 	} else if entry.IsDir() {
 		return errors.New(cleanPath + " is a directory")
 	}
-	delete(fs, cleanPath)
+	fs.remove(cleanPath)
+	return nil
+}
+
+// RemoveAll removes the file or the directory p together with all the
+// files it contains. Like os.RemoveAll, it succeeds if p does not exist.
+func (fs Filesystem) RemoveAll(p string) error {
+	cleanPath := filepath.Clean(p)
+	if cleanPath == "/" {
+		return errors.New("cannot remove root directory")
+	}
+	entry, exists := fs[cleanPath]
+	if !exists {
+		return nil
+	}
+	if entry.IsDir() {
+		paths := []string{}
+		fs.walk(cleanPath, false, func(df DummyFile) {
+			paths = append(paths, df.FullPath())
+		})
+		for _, fp := range paths {
+			fs.remove(fp)
+		}
+	}
+	fs.remove(cleanPath)
 	return nil
 }
 
+// checkRelocation returns an error, if the file source cannot be copied
+// or moved to destination, because it does not exist, because
+// destination already exists or because a directory would end up
+// inside of itself.
+func (fs Filesystem) checkRelocation(op, source, destination string) error {
+	file, exists := fs[source]
+	if !exists {
+		return errors.New(source + " does not exist in file system")
+	} else if _, exists := fs[destination]; exists {
+		return errors.New(destination + " already exists in file system")
+	} else if file.IsDir() && (source == "/" || inSubtree(destination, source)) {
+		return errors.New("cannot " + op + " " + source + " into itself")
+	}
+	return nil
+}
+
+// relocated returns the path of the file p in the subtree of source,
+// after source has been relocated to destination.
+func relocated(p, source, destination string) string {
+	return destination + strings.TrimPrefix(p, source)
+}
+
 // Copy copies a file from source to destination on the filesystem.
+// The metadata and the content of the file are copied as well. A
+// directory is copied together with all the files it contains, but it
+// cannot be copied into itself.
 func (fs Filesystem) Copy(source string, destination string) error {
-	sourcePath := filepath.Clean(source)
-	file, exists := fs[sourcePath]
-	if !exists {
-		return errors.New(sourcePath + " does not exist in file system")
+	sourcePath, destinationPath := filepath.Clean(source), filepath.Clean(destination)
+	err := fs.checkRelocation("copy", sourcePath, destinationPath)
+	if err != nil {
+		return err
 	}
+	file := fs[sourcePath]
 	if file.IsDir() {
-		return fs.MkDir(destination)
+		err = fs.MkDir(destinationPath)
+	} else {
+		err = fs.CreateFile(destinationPath)
+	}
+	if err != nil {
+		return err
+	}
+	entries := []DummyFile{file}
+	if file.IsDir() {
+		fs.walk(sourcePath, false, func(df DummyFile) {
+			entries = append(entries, df)
+		})
+	}
+	for _, df := range entries {
+		df.Path = relocated(df.FullPath(), sourcePath, destinationPath)
+		fs.set(df.Path, df)
 	}
-	return fs.CreateFile(destination)
+	return nil
 }
 
-// Move moves a file from source to destination on the filesystem.
+// Move moves a file from source to destination on the filesystem. A
+// directory is moved together with all the files it contains, but it
+// cannot be moved into itself.
 func (fs Filesystem) Move(source string, destination string) error {
-	err := fs.Copy(source, destination)
+	sourcePath, destinationPath := filepath.Clean(source), filepath.Clean(destination)
+	err := fs.checkRelocation("move", sourcePath, destinationPath)
 	if err != nil {
 		return err
 	}
-	file, _ := fs[filepath.Clean(source)]
+	file := fs[sourcePath]
+	directory := filepath.Dir(destinationPath)
+	if entry, exists := fs[directory]; !exists {
+		err = fs.MkDir(directory)
+		if err != nil {
+			return err
+		}
+	} else if !entry.IsDir() {
+		return errors.New(directory + " is not a directory")
+	}
+	entries := []DummyFile{file}
 	if file.IsDir() {
-		return fs.RemoveDirectory(source)
+		fs.walk(sourcePath, false, func(df DummyFile) {
+			entries = append(entries, df)
+		})
 	}
-	return fs.RemoveFile(source)
-
+	for _, df := range entries {
+		fs.remove(df.FullPath())
+	}
+	for _, df := range entries {
+		df.Path = relocated(df.FullPath(), sourcePath, destinationPath)
+		fs.set(df.Path, df)
+	}
+	return nil
 }
 
 // Dirs returns a list of all the directories on the filesystem.
@@ -170,32 +269,48 @@ func (fs Filesystem) RealFiles() []string {
 }
 
 // GetFiles returns all the regular files located at dir (if includeBaseFiles),
-// and in the subdirectories of dir.
+// and in the subdirectories of dir. The files are returned in the order of
+// filepath.Walk, just like on the real filesystem.
 func (fs Filesystem) GetFiles(dir string, includeBaseFiles bool) ([]osabstraction.FileInfo, error) {
 	files := []osabstraction.FileInfo{}
 	dir = path.Clean(dir)
-	for _, v := range fs {
-		if !v.IsDir() {
-			if v.Directory() == dir && includeBaseFiles {
-				files = append(files, v)
-			} else if v.Directory() != dir && strings.HasPrefix(v.FullPath(), dir) {
-				files = append(files, v)
-			}
-		}
+	if !fs.IsDirectory(dir) {
+		return nil, errors.New(dir + " is not a directory")
 	}
+	fs.walk(dir, false, func(df DummyFile) {
+		if !df.IsDir() && (includeBaseFiles || df.Directory() != dir) {
+			files = append(files, df)
+		}
+	})
 	return files, nil
 }
 
-// GetDirectories returns all the directories in the dir subtree.
+// GetDirectories returns all the directories in the dir subtree, ordered
+// like the files returned by GetFiles.
 func (fs Filesystem) GetDirectories(dir string) ([]osabstraction.FileInfo, error) {
 	files := []osabstraction.FileInfo{}
 	dir = path.Clean(dir)
-	for _, v := range fs {
-		if v.IsDir() {
-			if strings.HasPrefix(v.FullPath(), dir) && v.FullPath() != dir {
-				files = append(files, v)
-			}
+	if !fs.IsDirectory(dir) {
+		return nil, errors.New(dir + " is not a directory")
+	}
+	fs.walk(dir, true, func(df DummyFile) {
+		if df.IsDir() {
+			files = append(files, df)
 		}
+	})
+	return files, nil
+}
+
+// List returns the files and the directories located directly in dir
+// sorted by their names.
+func (fs Filesystem) List(dir string) ([]osabstraction.FileInfo, error) {
+	dir = path.Clean(dir)
+	if !fs.IsDirectory(dir) {
+		return nil, errors.New(dir + " is not a directory")
+	}
+	files := []osabstraction.FileInfo{}
+	for _, df := range fs.children(dir, false) {
+		files = append(files, df)
 	}
 	return files, nil
 }
@@ -232,26 +347,67 @@ func (fs Filesystem) RemoveSubDirectories(p string) error {
 	return nil
 }
 
+// ContentOptions controls, which information about the content of
+// the regular files is recorded by AddFromRealFilesystemWithContent.
+type ContentOptions struct {
+	// MaxInline is the maximum size of the files, whose content is
+	// stored in the Content field. 0 disables it.
+	MaxInline int64
+	// Hash enables storing the SHA-256 hash of every regular file.
+	Hash bool
+}
+
 // AddFromRealFilesystem adds all the files and directories from
-// a given path p to the simulated filesystem fs.
+// a given path p to the simulated filesystem fs, together with
+// their metadata.
 func (fs Filesystem) AddFromRealFilesystem(p string) error {
+	return fs.AddFromRealFilesystemWithContent(p, ContentOptions{})
+}
+
+// AddFromRealFilesystemWithContent does the same as AddFromRealFilesystem,
+// but additionally records the contents of the regular files or their
+// hashes according to opts. Symbolic links are not followed.
+func (fs Filesystem) AddFromRealFilesystemWithContent(p string, opts ContentOptions) error {
 	p = path.Clean(p)
 	err := filepath.Walk(p, func(path string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if fi.IsDir() {
-			// FIXME: ignoring errors:
-			fs.MkDir(path)
-		} else {
-			// FIXME: ignoring errors:
-			fs.CreateFile(path)
+		path = filepath.Clean(path)
+		df, exists := fs[path]
+		if exists && df.IsDir() != fi.IsDir() {
+			return errors.New(path + " already exists in filesystem with a different type")
+		} else if !exists {
+			if fi.IsDir() {
+				err = fs.MkDir(path)
+			} else {
+				err = fs.CreateFile(path)
+			}
+			if err != nil {
+				return err
+			}
+			df = fs[path]
 		}
+		df.setMetadata(fi)
+		if fi.Mode().IsRegular() {
+			err = df.readContent(opts)
+			if err != nil {
+				return err
+			}
+		}
+		fs[path] = df
 		return nil
 	})
 	return err
 }
 
+// Lookup returns the file p, and false if it does not exist in the
+// filesystem fs.
+func (fs Filesystem) Lookup(p string) (DummyFile, bool) {
+	df, exists := fs[path.Clean(p)]
+	return df, exists
+}
+
 // IsRegularFile returns true if a file p is a regular file
 // on the filesystem fs, otherwise false
 func (fs Filesystem) IsRegularFile(p string) bool {
@@ -301,10 +457,100 @@ func (fs Filesystem) Equal(f Filesystem) bool {
 	return true
 }
 
+// EqualWithMetadata tests, if two filesystems have exactly the same
+// structure, and if all the files have the same metadata. The metadata
+// and the contents of two files are only compared, if they are known in
+// both filesystems. Otherwise their hashes are compared, if both of
+// them are known.
+func (fs Filesystem) EqualWithMetadata(f Filesystem) bool {
+	if !fs.Equal(f) {
+		return false
+	}
+	for k, v := range fs {
+		if !v.equalMetadata(f[k]) {
+			return false
+		}
+	}
+	return true
+}
+
 // DummyFile represents a file on a simulated filesystem.
 type DummyFile struct {
 	Path        string
 	IsDirectory bool
+	// HasMetadata is true, if the following metadata is known, which
+	// is the case for the files added by AddFromRealFilesystem.
+	HasMetadata bool
+	// FileSize is the size of a regular file in bytes.
+	FileSize int64
+	// FileMode contains the type and the permission bits of the file.
+	FileMode os.FileMode
+	// ModTime is the time of the last modification.
+	ModTime time.Time
+	// UID and GID are the numeric IDs of the owner and the group.
+	// They are -1, if they are not supported by the operating system.
+	UID, GID int
+	// Content is the content of a regular file. It is nil, if the
+	// content is unknown.
+	Content []byte
+	// Hash is the hex encoded SHA-256 hash of the content of a regular
+	// file, or empty if it is unknown.
+	Hash string
+}
+
+// setMetadata sets the metadata of df from the information fi about
+// a file on the real filesystem.
+func (df *DummyFile) setMetadata(fi os.FileInfo) {
+	df.HasMetadata = true
+	df.FileMode = fi.Mode()
+	df.ModTime = fi.ModTime()
+	df.UID, df.GID = owner(fi)
+	if !fi.IsDir() {
+		df.FileSize = fi.Size()
+	}
+}
+
+// readContent reads the content of df from the real filesystem and
+// stores the content or its hash according to opts.
+func (df *DummyFile) readContent(opts ContentOptions) error {
+	inline := opts.MaxInline > 0 && df.FileSize <= opts.MaxInline
+	if !inline && !opts.Hash {
+		return nil
+	}
+	f, err := os.Open(df.FullPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	var r io.Reader = f
+	var content bytes.Buffer
+	if inline {
+		r = io.TeeReader(f, &content)
+	}
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	if inline {
+		df.Content = content.Bytes()
+	}
+	if opts.Hash {
+		df.Hash = hex.EncodeToString(h.Sum(nil))
+	}
+	return nil
+}
+
+// equalMetadata tests, if df and f have the same metadata, as far as it
+// is known in both, and the same content, as far as it is known.
+func (df DummyFile) equalMetadata(f DummyFile) bool {
+	return len(df.diffFields(f)) == 0
+}
+
+// Size returns the size of df in bytes.
+func (df DummyFile) Size() int64 {
+	return df.FileSize
 }
 
 // IsDir checks if df is a directory.
@@ -1,7 +1,15 @@
 package filesystem_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/goggle/flatten/filesystem"
 )
@@ -213,6 +221,43 @@ func TestFilesystemOne(t *testing.T) {
 	}
 }
 
+// TestDirectWrites checks that the entries, which are written to the
+// map directly, are found by the queries as well.
+func TestDirectWrites(t *testing.T) {
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	noErrorExpected(t, fs.CreateFile("/a/x.txt"))
+	fs["/a/b"] = filesystem.DummyFile{Path: "/a/b", IsDirectory: true}
+	fs["/a/b/y.txt"] = filesystem.DummyFile{Path: "/a/b/y.txt"}
+	fs["/c"] = filesystem.DummyFile{Path: "/c", IsDirectory: true}
+
+	files, err := fs.GetFiles("/", true)
+	noErrorExpected(t, err)
+	if !reflect.DeepEqual(paths(files), []string{"/a/b/y.txt", "/a/x.txt"}) {
+		t.Errorf("GetFiles: Expected the directly written file, got %v", paths(files))
+	}
+	entries, err := fs.ReadDir(".")
+	noErrorExpected(t, err)
+	if len(entries) != 2 || entries[0].Name() != "a" || entries[1].Name() != "c" {
+		t.Errorf("ReadDir: Expected a and c, got %v", entries)
+	}
+	if err := fs.RemoveDirectory("/a/b"); err == nil {
+		t.Errorf("RemoveDirectory: Expected an error for a directory, which is not empty")
+	}
+	delete(fs, "/a/b/y.txt")
+	noErrorExpected(t, fs.RemoveSubDirectories("/a"))
+	dirs, err := fs.GetDirectories("/")
+	noErrorExpected(t, err)
+	if !reflect.DeepEqual(paths(dirs), []string{"/a", "/c"}) {
+		t.Errorf("GetDirectories: Expected /a and /c, got %v", paths(dirs))
+	}
+
+	// The entries are plain values without any index:
+	if !reflect.DeepEqual(fs["/a"], filesystem.DummyFile{Path: "/a", IsDirectory: true}) {
+		t.Errorf("Expected a plain directory, got %#v", fs["/a"])
+	}
+}
+
 func TestDummyFile(t *testing.T) {
 	df := filesystem.DummyFile{Path: "/home/goggle/test/my_song.flac", IsDirectory: false}
 
@@ -307,3 +352,536 @@ func TestEqual(t *testing.T) {
 		t.Errorf("Equal: expected %v, got %v", expected, result)
 	}
 }
+
+func TestMetadata(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0640)
+	noErrorExpected(t, err)
+	err = os.Chmod(filepath.Join(dir, "hello.txt"), 0640)
+	noErrorExpected(t, err)
+
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	err = fs.AddFromRealFilesystemWithContent(dir, filesystem.ContentOptions{MaxInline: 1024, Hash: true})
+	noErrorExpected(t, err)
+
+	df := fs[filepath.Join(dir, "hello.txt")]
+	if !df.HasMetadata || df.Size() != 5 || df.FileMode.Perm() != 0640 || df.ModTime.IsZero() {
+		t.Errorf("AddFromRealFilesystemWithContent: Expected the metadata of hello.txt, got %+v", df)
+	}
+	if string(df.Content) != "hello" {
+		t.Errorf("AddFromRealFilesystemWithContent: Expected the content hello, got %q", df.Content)
+	}
+	expectedHash := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if df.Hash != expectedHash {
+		t.Errorf("AddFromRealFilesystemWithContent: Expected the hash %v, got %v", expectedHash, df.Hash)
+	}
+
+	err = fs.Copy(df.FullPath(), filepath.Join(dir, "copy.txt"))
+	noErrorExpected(t, err)
+	cp := fs[filepath.Join(dir, "copy.txt")]
+	if cp.Size() != 5 || cp.FileMode != df.FileMode || !cp.ModTime.Equal(df.ModTime) || cp.Hash != df.Hash {
+		t.Errorf("Copy: Expected the metadata to be copied, got %+v", cp)
+	}
+
+	other := filesystem.Filesystem{}
+	other.Init()
+	err = other.AddFromRealFilesystem(dir)
+	noErrorExpected(t, err)
+	fs.RemoveFile(filepath.Join(dir, "copy.txt"))
+	if !fs.EqualWithMetadata(other) {
+		t.Errorf("EqualWithMetadata: Expected the filesystems to be equal")
+	}
+	changed := other[df.FullPath()]
+	changed.FileMode = 0600
+	other[df.FullPath()] = changed
+	if !fs.Equal(other) || fs.EqualWithMetadata(other) {
+		t.Errorf("EqualWithMetadata: Expected a different mode to be detected")
+	}
+}
+
+func TestRecursive(t *testing.T) {
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	for _, p := range []string{"/a/b/c/x.txt", "/a/b/y.txt", "/a/z.txt", "/ab/w.txt"} {
+		noErrorExpected(t, fs.CreateFile(p))
+	}
+	df := fs["/a/b/y.txt"]
+	df.Content = []byte("y")
+	fs["/a/b/y.txt"] = df
+
+	err := fs.Move("/a", "/a/b/a")
+	if err == nil {
+		t.Errorf("Move: Expected an error when moving a directory into itself")
+	}
+	err = fs.Copy("/a", "/a/b/a")
+	if err == nil {
+		t.Errorf("Copy: Expected an error when copying a directory into itself")
+	}
+	err = fs.Move("/a/b", "/ab")
+	if err == nil {
+		t.Errorf("Move: Expected an error when the destination exists")
+	}
+
+	noErrorExpected(t, fs.Copy("/a/b", "/copy/b"))
+	noErrorExpected(t, fs.Move("/a", "/ab/a"))
+	expected := []string{"/ab/a/b/c/x.txt", "/ab/a/b/y.txt", "/ab/a/z.txt", "/ab/w.txt", "/copy/b/c/x.txt", "/copy/b/y.txt"}
+	if !matchLists(fs.RealFiles(), expected) {
+		t.Errorf("Move and Copy: Expected the files %v, got %v", expected, fs.RealFiles())
+	}
+	files, err := fs.GetFiles("/ab/a", true)
+	noErrorExpected(t, err)
+	if len(files) != 3 || files[1].FullPath() != "/ab/a/b/y.txt" {
+		t.Errorf("GetFiles: Expected the moved files, got %v", files)
+	}
+	if string(fs["/copy/b/y.txt"].Content) != "y" {
+		t.Errorf("Copy: Expected the content to be copied")
+	}
+
+	noErrorExpected(t, fs.RemoveAll("/ab/a/b"))
+	noErrorExpected(t, fs.RemoveAll("/missing"))
+	expected = []string{"/ab/a/z.txt", "/ab/w.txt", "/copy/b/c/x.txt", "/copy/b/y.txt"}
+	if !matchLists(fs.RealFiles(), expected) {
+		t.Errorf("RemoveAll: Expected the files %v, got %v", expected, fs.RealFiles())
+	}
+	dirs, err := fs.GetDirectories("/ab")
+	noErrorExpected(t, err)
+	if len(dirs) != 1 || dirs[0].FullPath() != "/ab/a" {
+		t.Errorf("RemoveAll: Expected only /ab/a to remain, got %v", dirs)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	noErrorExpected(t, os.MkdirAll(filepath.Join(dir, "a", "b"), 0755))
+	noErrorExpected(t, os.WriteFile(filepath.Join(dir, "a", "x.txt"), []byte("x"), 0644))
+	noErrorExpected(t, os.WriteFile(filepath.Join(dir, "a", "b", "y.bin"), []byte{0, 255}, 0600))
+
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	err := fs.AddFromRealFilesystemWithContent(dir, filesystem.ContentOptions{MaxInline: 1024, Hash: true})
+	noErrorExpected(t, err)
+
+	for _, format := range []filesystem.Format{filesystem.FormatJSON, filesystem.FormatYAML} {
+		var b bytes.Buffer
+		noErrorExpected(t, fs.WriteSnapshot(&b, format))
+		loaded, err := filesystem.ReadSnapshot(&b, format)
+		noErrorExpected(t, err)
+		if !fs.EqualWithMetadata(loaded) {
+			t.Errorf("ReadSnapshot(%v): Expected the same filesystem, got the differences\n%v", format, fs.Diff(loaded))
+		}
+		if !fs.Diff(loaded).Empty() {
+			t.Errorf("Diff(%v): Expected no differences, got\n%v", format, fs.Diff(loaded))
+		}
+		files, err := loaded.GetFiles(dir, true)
+		noErrorExpected(t, err)
+		if len(files) != 2 {
+			t.Errorf("ReadSnapshot(%v): Expected the index to be restored, got %v", format, files)
+		}
+	}
+
+	golden := `{
+  "entries": [
+    {
+      "path": "/",
+      "type": "directory"
+    },
+    {
+      "path": "/a",
+      "type": "directory",
+      "metadata": {
+        "size": 0,
+        "mode": "0755",
+        "mtime": "2024-01-02T03:04:05Z",
+        "uid": 1000,
+        "gid": 100
+      }
+    },
+    {
+      "path": "/a/x.txt",
+      "type": "file",
+      "content": "eA=="
+    }
+  ]
+}
+`
+	loaded, err := filesystem.ReadSnapshot(bytes.NewBufferString(golden), filesystem.FormatJSON)
+	noErrorExpected(t, err)
+	a := loaded["/a"]
+	if !a.HasMetadata || a.FileMode != os.ModeDir|0755 || a.UID != 1000 || string(loaded["/a/x.txt"].Content) != "x" {
+		t.Errorf("ReadSnapshot: Expected the golden snapshot, got %+v", loaded)
+	}
+	var b bytes.Buffer
+	noErrorExpected(t, loaded.WriteSnapshot(&b, filesystem.FormatJSON))
+	if b.String() != golden {
+		t.Errorf("WriteSnapshot: Expected\n%v\ngot\n%v", golden, b.String())
+	}
+
+	_, err = filesystem.ReadSnapshot(bytes.NewBufferString(`{"entries": [{"path": "a", "type": "file"}]}`), filesystem.FormatJSON)
+	if err == nil {
+		t.Errorf("ReadSnapshot: Expected an error for a relative path")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := filesystem.Filesystem{}
+	old.Init()
+	noErrorExpected(t, old.CreateFile("/a/x.txt"))
+	noErrorExpected(t, old.CreateFile("/a/y.txt"))
+	noErrorExpected(t, old.CreateFile("/b"))
+	x := old["/a/x.txt"]
+	x.Content = []byte("x")
+	old["/a/x.txt"] = x
+
+	new := filesystem.Filesystem{}
+	new.Init()
+	noErrorExpected(t, new.CreateFile("/a/x.txt"))
+	noErrorExpected(t, new.CreateFile("/a/z.txt"))
+	noErrorExpected(t, new.MkDir("/b"))
+	x.Content = []byte("changed")
+	new["/a/x.txt"] = x
+
+	d := old.Diff(new)
+	if len(d.Added) != 1 || d.Added[0].Path != "/a/z.txt" {
+		t.Errorf("Diff: Expected /a/z.txt to be added, got %v", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Path != "/a/y.txt" {
+		t.Errorf("Diff: Expected /a/y.txt to be removed, got %v", d.Removed)
+	}
+	fields := [][]string{}
+	for _, c := range d.Changed {
+		fields = append(fields, append([]string{c.New.Path}, c.Fields...))
+	}
+	expected := [][]string{{"/a/x.txt", "content"}, {"/b", "type"}}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Diff: Expected the changes %v, got %v", expected, fields)
+	}
+	expectedString := "- /a/y.txt\n+ /a/z.txt\n~ /a/x.txt (content)\n~ /b (type)\n"
+	if d.String() != expectedString {
+		t.Errorf("Diff: Expected\n%v\ngot\n%v", expectedString, d.String())
+	}
+	if !old.Diff(old).Empty() {
+		t.Errorf("Diff: Expected no differences to itself")
+	}
+
+	// The metadata is not compared, if it is only known on one side:
+	full := filesystem.Filesystem{}
+	full.Init()
+	noErrorExpected(t, full.CreateFile("/a/x.txt"))
+	bare := filesystem.Filesystem{}
+	bare.Init()
+	noErrorExpected(t, bare.CreateFile("/a/x.txt"))
+	x = full["/a/x.txt"]
+	x.HasMetadata, x.FileSize, x.FileMode, x.ModTime, x.UID, x.GID = true, 3, 0640, time.Unix(1700000000, 0), 1000, 1000
+	full["/a/x.txt"] = x
+	if d := full.Diff(bare); !d.Empty() {
+		t.Errorf("Diff: Expected no differences without the metadata on one side, got\n%v", d)
+	}
+	if !full.EqualWithMetadata(bare) || !bare.EqualWithMetadata(full) {
+		t.Errorf("EqualWithMetadata: Expected the metadata to be ignored, if it is only known on one side")
+	}
+	y := x
+	y.FileMode = 0600
+	bare["/a/x.txt"] = y
+	if d := full.Diff(bare); len(d.Changed) != 1 || !reflect.DeepEqual(d.Changed[0].Fields, []string{"mode"}) || full.EqualWithMetadata(bare) {
+		t.Errorf("Diff: Expected the mode to differ, got\n%v", d)
+	}
+}
+
+func TestListing(t *testing.T) {
+	listings := []struct {
+		format  filesystem.Format
+		listing string
+	}{
+		{filesystem.FormatFind, "d 4096 1700000000.5 755 /src\nd 4096 1700000000 755 /src/a\nf 3 1700000000 640 /src/a/x y.txt\nd 4096 1700000000 755 /dst\n"},
+		{filesystem.FormatFind, "/src\n/src/a\n/src/a/x y.txt\n/dst\n"},
+		{filesystem.FormatNUL, "/src/a/x y.txt\x00/dst\x00"},
+		{filesystem.FormatJSON, `[{"path": "/src/a/x y.txt", "size": 3, "mtime": "2023-11-14T22:13:20Z"}, {"path": "/dst", "type": "directory"}]`},
+		{filesystem.FormatCSV, "size,path\n3,/src/a/x y.txt\n,/dst/\n"},
+	}
+	for _, l := range listings {
+		fs, err := filesystem.ReadListing(bytes.NewBufferString(l.listing), l.format, "/dst")
+		if err != nil {
+			t.Errorf("ReadListing(%v): No error expected, got %v", l.format, err)
+			continue
+		}
+		expected := []string{"/", "/src", "/src/a", "/dst"}
+		if !matchLists(fs.Dirs(), expected) || !matchLists(fs.RealFiles(), []string{"/src/a/x y.txt"}) {
+			t.Errorf("ReadListing(%v): Expected the directories %v and one file, got %v and %v", l.format, expected, fs.Dirs(), fs.RealFiles())
+		}
+		df := fs["/src/a/x y.txt"]
+		if df.HasMetadata && (df.Size() != 3 || !df.ModTime.IsZero() && df.ModTime.Unix() != 1700000000) {
+			t.Errorf("ReadListing(%v): Expected the metadata of the file, got %+v", l.format, df)
+		}
+	}
+
+	fs, err := filesystem.ReadListing(bytes.NewBufferString(listings[0].listing), filesystem.FormatFind)
+	noErrorExpected(t, err)
+	if fs["/src/a/x y.txt"].FileMode != 0640 || fs["/src"].FileMode != os.ModeDir|0755 {
+		t.Errorf("ReadListing: Expected the modes of the find listing, got %v and %v", fs["/src/a/x y.txt"].FileMode, fs["/src"].FileMode)
+	}
+	if fs["/src"].ModTime.Nanosecond() != 5e8 {
+		t.Errorf("ReadListing: Expected the fractional modification time, got %v", fs["/src"].ModTime)
+	}
+
+	for format, listing := range map[filesystem.Format]string{
+		filesystem.FormatFind: "src/a\n",
+		filesystem.FormatCSV:  "name\n/src\n",
+		filesystem.FormatJSON: `[{"path": "/src", "type": "socket file"}]`,
+	} {
+		_, err := filesystem.ReadListing(bytes.NewBufferString(listing), format)
+		if err == nil {
+			t.Errorf("ReadListing(%v): Expected an error for %q", format, listing)
+		}
+	}
+
+	fs, err = filesystem.ReadListing(bytes.NewBufferString("p 0 1700000000 644 /src/fifo\ns 0 1700000000 755 /src/socket\nc 0 1700000000 620 /src/tty\n"), filesystem.FormatFind)
+	noErrorExpected(t, err)
+	if !matchLists(fs.RealFiles(), []string{"/src/fifo", "/src/socket", "/src/tty"}) {
+		t.Errorf("ReadListing: Expected the special files to be regular files, got %v", fs.RealFiles())
+	}
+
+	// The parents of a known directory are directories as well:
+	fs, err = filesystem.ReadListing(bytes.NewBufferString("/dst\n/dst/a/x.txt\n"), filesystem.FormatFind, "/dst/a")
+	noErrorExpected(t, err)
+	if !matchLists(fs.Dirs(), []string{"/", "/dst", "/dst/a"}) {
+		t.Errorf("ReadListing: Expected the directories /, /dst and /dst/a, got %v", fs.Dirs())
+	}
+
+	detections := []struct {
+		name     string
+		head     string
+		expected filesystem.Format
+	}{
+		{"files.CSV", "", filesystem.FormatCSV},
+		{"files", "/a\x00/b\x00", filesystem.FormatNUL},
+		{"files", ` {"entries": []}`, filesystem.FormatJSON},
+		{"files.txt", "/a\n/b\n", filesystem.FormatFind},
+	}
+	for _, d := range detections {
+		result := filesystem.DetectListingFormat(d.name, []byte(d.head))
+		if result != d.expected {
+			t.Errorf("DetectListingFormat(%v, %q): Expected %v, got %v", d.name, d.head, d.expected, result)
+		}
+	}
+}
+
+func TestConstrained(t *testing.T) {
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	for _, d := range []string{"/src/ro/sub", "/src/rw", "/dst"} {
+		noErrorExpected(t, fs.MkDir(d))
+	}
+	for _, f := range []string{"/src/ro/a.txt", "/src/rw/b.txt", "/src/rw/c.txt"} {
+		noErrorExpected(t, fs.CreateFile(f))
+	}
+	setMetadata := func(p string, mode os.FileMode, uid int, size int64) {
+		df := fs[p]
+		df.HasMetadata = true
+		df.FileMode = mode
+		df.UID, df.GID = uid, 100
+		df.FileSize = size
+		fs[p] = df
+	}
+	setMetadata("/src/ro", os.ModeDir|0555, 1000, 0)
+	setMetadata("/src/rw", os.ModeDir|0755, 1000, 0)
+	setMetadata("/dst", os.ModeDir|0775, 0, 0)
+	setMetadata("/src/ro/a.txt", 0644, 1000, 10)
+	setMetadata("/src/rw/b.txt", 0600, 0, 10)
+	setMetadata("/src/rw/c.txt", 0644, 1000, 30)
+
+	c := &filesystem.Constrained{Simulated: fs, UID: 1000, GIDs: []int{100}, Free: 25, Volume: "/dst"}
+
+	err := c.Move("/src/ro/a.txt", "/dst/a.txt")
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Move: Expected a permission error for a read-only directory, got %v", err)
+	}
+	err = c.Move("/src/rw/c.txt", "/dst/c.txt")
+	noErrorExpected(t, err)
+	if c.Free != 25 {
+		t.Errorf("Move: Expected no space to be consumed, got %v bytes left", c.Free)
+	}
+
+	err = c.Copy("/src/rw/b.txt", "/dst/b.txt")
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Copy: Expected a permission error for an unreadable file, got %v", err)
+	}
+	err = c.Copy("/src/ro/a.txt", "/dst/a.txt")
+	noErrorExpected(t, err)
+	err = c.Copy("/dst/c.txt", "/dst/c_1.txt")
+	if !errors.Is(err, filesystem.ErrNoSpace) {
+		t.Errorf("Copy: Expected %v, got %v", filesystem.ErrNoSpace, err)
+	}
+	if c.Free != 15 || fs.Exists("/dst/c_1.txt") {
+		t.Errorf("Copy: Expected 15 bytes left and no copy, got %v bytes left", c.Free)
+	}
+
+	err = c.RemoveSubDirectories("/src")
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("RemoveSubDirectories: Expected a permission error, got %v", err)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	noErrorExpected(t, os.MkdirAll(filepath.Join(dir, "a", "b"), 0755))
+	noErrorExpected(t, os.WriteFile(filepath.Join(dir, "a", "b", "x.txt"), []byte("xyz"), 0644))
+	noErrorExpected(t, os.WriteFile(filepath.Join(dir, "a", "y.txt"), nil, 0644))
+
+	o := filesystem.NewOverlay()
+	err := o.Move(filepath.Join(dir, "a", "b", "x.txt"), filepath.Join(dir, "x.txt"))
+	noErrorExpected(t, err)
+	err = o.Copy(filepath.Join(dir, "a", "y.txt"), filepath.Join(dir, "y.txt"))
+	noErrorExpected(t, err)
+	err = o.Copy(filepath.Join(dir, "a", "y.txt"), filepath.Join(dir, "x.txt"))
+	if err == nil {
+		t.Errorf("Copy: Expected an error for an existing destination")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "a", "b", "x.txt")); err != nil {
+		t.Errorf("Move: Expected the real file to be untouched, got %v", err)
+	}
+	if o.Exists(filepath.Join(dir, "a", "b", "x.txt")) || !o.IsRegularFile(filepath.Join(dir, "x.txt")) {
+		t.Errorf("Move: Expected x.txt to be moved in the overlay")
+	}
+	df, _ := o.Lookup(filepath.Join(dir, "x.txt"))
+	if df.Size() != 3 {
+		t.Errorf("Move: Expected the size 3 to be preserved, got %v", df.Size())
+	}
+
+	files, err := o.GetFiles(dir, true)
+	noErrorExpected(t, err)
+	result := []string{}
+	for _, f := range files {
+		result = append(result, f.FullPath())
+	}
+	expected := []string{filepath.Join(dir, "a", "y.txt"), filepath.Join(dir, "x.txt"), filepath.Join(dir, "y.txt")}
+	if !matchLists(result, expected) {
+		t.Errorf("GetFiles: Expected %v, got %v", expected, result)
+	}
+
+	err = o.RemoveSubDirectories(dir)
+	if err == nil {
+		t.Errorf("RemoveSubDirectories: Expected an error for a non-empty directory")
+	}
+	if !o.IsDirectory(filepath.Join(dir, "a")) || o.Exists(filepath.Join(dir, "a", "b")) {
+		t.Errorf("RemoveSubDirectories: Expected only the empty directory to be removed")
+	}
+	noErrorExpected(t, o.Move(filepath.Join(dir, "a", "y.txt"), filepath.Join(dir, "z.txt")))
+	noErrorExpected(t, o.RemoveSubDirectories(dir))
+	dirs, err := o.GetDirectories(dir)
+	noErrorExpected(t, err)
+	if len(dirs) != 0 {
+		t.Errorf("RemoveSubDirectories: Expected no directories, got %v", dirs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a", "b")); err != nil {
+		t.Errorf("RemoveSubDirectories: Expected the real directory to be untouched, got %v", err)
+	}
+}
+
+func TestIOFS(t *testing.T) {
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	noErrorExpected(t, fs.MkDir("/a/empty"))
+	noErrorExpected(t, fs.CreateFile("/a/hello.txt"))
+	noErrorExpected(t, fs.CreateFile("/b.txt"))
+	df := fs["/a/hello.txt"]
+	df.Content = []byte("hello")
+	fs["/a/hello.txt"] = df
+
+	err := fstest.TestFS(fs, "a/hello.txt", "a/empty", "b.txt")
+	if err != nil {
+		t.Errorf("TestFS: No error expected, got %v", err)
+	}
+
+	content, err := fs.ReadFile("a/hello.txt")
+	noErrorExpected(t, err)
+	if string(content) != "hello" {
+		t.Errorf("ReadFile: Expected hello, got %q", content)
+	}
+
+	df = fs["/b.txt"]
+	df.HasMetadata = true
+	df.FileSize = 3
+	fs["/b.txt"] = df
+	_, err = fs.ReadFile("b.txt")
+	if !errors.Is(err, filesystem.ErrContentUnknown) {
+		t.Errorf("ReadFile: Expected %v, got %v", filesystem.ErrContentUnknown, err)
+	}
+}
+
+func TestFSOverlay(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"a/x.txt":   {Data: []byte("xyz")},
+		"a/b/y.txt": {Data: []byte("y")},
+	}
+	o := filesystem.NewFSOverlay(mapFS, "/mnt")
+	if !o.IsDirectory("/mnt/a/b") || !o.IsRegularFile("/mnt/a/x.txt") || o.Exists("/a") {
+		t.Errorf("NewFSOverlay: Expected the files to be mounted at /mnt")
+	}
+
+	noErrorExpected(t, o.Move("/mnt/a/b/y.txt", "/mnt/y.txt"))
+	files, err := o.GetFiles("/mnt", true)
+	noErrorExpected(t, err)
+	result := []string{}
+	for _, f := range files {
+		result = append(result, f.FullPath())
+	}
+	expected := []string{"/mnt/a/x.txt", "/mnt/y.txt"}
+	if !matchLists(result, expected) {
+		t.Errorf("GetFiles: Expected %v, got %v", expected, result)
+	}
+	if _, exists := mapFS["a/b/y.txt"]; !exists {
+		t.Errorf("Move: Expected the lower filesystem to be untouched")
+	}
+}
+
+func BenchmarkFilesystem(b *testing.B) {
+	for _, n := range []int{100000, 1000000} {
+		fs := filesystem.SyntheticFilesystem(n)
+		b.Run(fmt.Sprintf("GetFiles/subtree/%v", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				files, _ := fs.GetFiles("/data/d000/d000", true)
+				if len(files) != 100 {
+					b.Fatalf("BenchmarkFilesystem: Expected 100 files, got %v", len(files))
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("RemoveDirectory/%v", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				fs.MkDir("/empty")
+				err := fs.RemoveDirectory("/empty")
+				if err != nil {
+					b.Fatalf("BenchmarkFilesystem: No error expected, got %v", err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("ReadDir/%v", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				entries, _ := fs.ReadDir("data/d000/d000")
+				if len(entries) != 100 {
+					b.Fatalf("BenchmarkFilesystem: Expected 100 entries, got %v", len(entries))
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("GetDirectories/%v", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				fs.GetDirectories("/data")
+			}
+		})
+		b.Run(fmt.Sprintf("RemoveSubDirectories/%v", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				dirsOnly := filesystem.SyntheticFilesystem(n)
+				for _, f := range dirsOnly.RealFiles() {
+					dirsOnly.RemoveFile(f)
+				}
+				b.StartTimer()
+				err := dirsOnly.RemoveSubDirectories("/data")
+				if err != nil {
+					b.Fatalf("BenchmarkFilesystem: No error expected, got %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package filesystem
+
+import (
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"weak"
+)
+
+// dirIndex maps the directories of a Filesystem to the names of their
+// entries, so that the queries on a subtree only visit its entries.
+type dirIndex struct {
+	entries map[string]map[string]struct{}
+	// size is the number of entries of the filesystem, when it has
+	// been indexed or changed by its methods for the last time.
+	size int
+}
+
+// newDirIndex returns the index of all the entries of fs.
+func newDirIndex(fs Filesystem) *dirIndex {
+	idx := &dirIndex{entries: map[string]map[string]struct{}{}, size: len(fs)}
+	for p := range fs {
+		idx.add(p)
+	}
+	return idx
+}
+
+// add adds the entry p to the index of its directory.
+func (idx *dirIndex) add(p string) {
+	if p == "/" {
+		return
+	}
+	dir := path.Dir(p)
+	if idx.entries[dir] == nil {
+		idx.entries[dir] = map[string]struct{}{}
+	}
+	idx.entries[dir][path.Base(p)] = struct{}{}
+}
+
+// remove removes the entry p from the index of its directory.
+func (idx *dirIndex) remove(p string) {
+	dir := path.Dir(p)
+	delete(idx.entries[dir], path.Base(p))
+	if len(idx.entries[dir]) == 0 {
+		delete(idx.entries, dir)
+	}
+}
+
+// children returns the entries of the directory dir of fs sorted by
+// their names, or only its subdirectories if dirsOnly is true.
+func (idx *dirIndex) children(fs Filesystem, dir string, dirsOnly bool) []DummyFile {
+	names := make([]string, 0, len(idx.entries[dir]))
+	for name := range idx.entries[dir] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	prefix := subtreePrefix(dir)
+	entries := make([]DummyFile, 0, len(names))
+	for _, name := range names {
+		if df := fs[prefix+name]; df.IsDir() || !dirsOnly {
+			entries = append(entries, df)
+		}
+	}
+	return entries
+}
+
+// walk calls fn for every entry of fs in the subtree of dir (without
+// dir itself), with every directory being visited before its entries.
+// If dirsOnly is true, only the directories are visited.
+func (idx *dirIndex) walk(fs Filesystem, dir string, dirsOnly bool, fn func(df DummyFile)) {
+	for _, df := range idx.children(fs, dir, dirsOnly) {
+		fn(df)
+		if df.IsDir() {
+			idx.walk(fs, df.FullPath(), dirsOnly, fn)
+		}
+	}
+}
+
+// indexed is the index of a filesystem, which is only weakly
+// referenced, so that it does not keep the filesystem alive.
+type indexed struct {
+	fs  weak.Pointer[byte]
+	idx *dirIndex
+}
+
+// indices contains the indices of the filesystems by the addresses of
+// their maps. The entries are removed, once their filesystems have been
+// garbage collected.
+var indices = struct {
+	sync.Mutex
+	m map[uintptr]indexed
+}{m: map[uintptr]indexed{}}
+
+// index returns the index of the directories of fs, which is built on
+// first use and then kept up to date by the methods, which change fs.
+// It is rebuilt, if the number of entries has been changed by writing
+// to the map directly.
+func (fs Filesystem) index() *dirIndex {
+	if fs == nil {
+		return newDirIndex(fs)
+	}
+	v := reflect.ValueOf(fs)
+	key, m := v.Pointer(), (*byte)(v.UnsafePointer())
+	indices.Lock()
+	defer indices.Unlock()
+	entry, exists := indices.m[key]
+	if !exists || entry.fs.Value() != m {
+		// The address might belong to a filesystem, which has been
+		// garbage collected already:
+		entry = indexed{fs: weak.Make(m)}
+		runtime.AddCleanup(m, func(wp weak.Pointer[byte]) {
+			indices.Lock()
+			defer indices.Unlock()
+			if indices.m[key].fs == wp {
+				delete(indices.m, key)
+			}
+		}, entry.fs)
+	}
+	if entry.idx == nil || entry.idx.size != len(fs) {
+		entry.idx = newDirIndex(fs)
+		indices.m[key] = entry
+	}
+	return entry.idx
+}
+
+// set writes the entry df at the path p and adds it to the index.
+func (fs Filesystem) set(p string, df DummyFile) {
+	idx := fs.index()
+	fs[p] = df
+	idx.add(p)
+	idx.size = len(fs)
+}
+
+// remove deletes the entry at the path p and removes it from the index.
+func (fs Filesystem) remove(p string) {
+	idx := fs.index()
+	delete(fs, p)
+	idx.remove(p)
+	idx.size = len(fs)
+}
+
+// children returns the entries of the directory dir sorted by their
+// names, or only its subdirectories if dirsOnly is true.
+func (fs Filesystem) children(dir string, dirsOnly bool) []DummyFile {
+	return fs.index().children(fs, dir, dirsOnly)
+}
+
+// walk calls fn for every entry in the subtree of dir (without dir
+// itself), with every directory being visited before its entries. If
+// dirsOnly is true, only the directories are visited.
+func (fs Filesystem) walk(dir string, dirsOnly bool, fn func(df DummyFile)) {
+	fs.index().walk(fs, dir, dirsOnly, fn)
+}
+
+// isEmpty returns true if the directory dir does not contain any files.
+func (fs Filesystem) isEmpty(dir string) bool {
+	return len(fs.index().entries[dir]) == 0
+}
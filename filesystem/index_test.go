@@ -0,0 +1,44 @@
+package filesystem
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	fs := Filesystem{}
+	fs.Init()
+	fs.CreateFile("/a/b/x.txt")
+	idx := fs.index()
+	ops := []struct {
+		name string
+		op   func() error
+	}{
+		{"MkDir", func() error { return fs.MkDir("/c/d") }},
+		{"CreateFile", func() error { return fs.CreateFile("/c/d/y.txt") }},
+		{"Copy", func() error { return fs.Copy("/a", "/c/a") }},
+		{"Move", func() error { return fs.Move("/c/d", "/e/d") }},
+		{"RemoveFile", func() error { return fs.RemoveFile("/e/d/y.txt") }},
+		{"RemoveSubDirectories", func() error { return fs.RemoveSubDirectories("/e") }},
+		{"RemoveAll", func() error { return fs.RemoveAll("/c") }},
+		{"RemoveDirectory", func() error { return fs.RemoveDirectory("/e") }},
+	}
+	for _, test := range ops {
+		err := test.op()
+		if err != nil {
+			t.Fatalf("%v: No error expected, got %v", test.name, err)
+		}
+		if fs.index() != idx {
+			t.Errorf("%v: Expected the index to be kept up to date instead of being rebuilt", test.name)
+		}
+		if !reflect.DeepEqual(idx.entries, newDirIndex(fs).entries) {
+			t.Errorf("%v: Expected the index %v, got %v", test.name, newDirIndex(fs).entries, idx.entries)
+		}
+	}
+
+	// Writing to the map directly makes the index be rebuilt:
+	fs["/a/b/y.txt"] = DummyFile{Path: "/a/b/y.txt"}
+	if fs.index() == idx || len(fs.children("/a/b", false)) != 2 {
+		t.Errorf("Expected the index to be rebuilt after a direct write")
+	}
+}
@@ -0,0 +1,199 @@
+package filesystem
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"path"
+	"time"
+)
+
+// ErrContentUnknown is returned when reading a simulated file, whose
+// content has not been recorded (see ContentOptions).
+var ErrContentUnknown = errors.New("content of the simulated file is unknown")
+
+// Filesystem implements the interfaces of the io/fs package, so that
+// it can be used with fs.WalkDir, fstest, templates and the like:
+var (
+	_ iofs.FS         = Filesystem{}
+	_ iofs.StatFS     = Filesystem{}
+	_ iofs.ReadDirFS  = Filesystem{}
+	_ iofs.ReadFileFS = Filesystem{}
+)
+
+// fileInfo describes a dummy file as an fs.FileInfo.
+type fileInfo struct {
+	df DummyFile
+}
+
+// Name returns the name of the file, which is "." for the root.
+func (fi fileInfo) Name() string {
+	if fi.df.FullPath() == "/" {
+		return "."
+	}
+	return fi.df.Name()
+}
+
+// Size returns the size of a regular file in bytes.
+func (fi fileInfo) Size() int64 {
+	if !fi.df.HasMetadata && fi.df.Content != nil {
+		return int64(len(fi.df.Content))
+	}
+	return fi.df.FileSize
+}
+
+// Mode returns the recorded mode of the file. If the metadata is
+// unknown, directories have the mode 0755 and files the mode 0644.
+func (fi fileInfo) Mode() iofs.FileMode {
+	mode := fi.df.FileMode
+	if !fi.df.HasMetadata {
+		mode = 0644
+		if fi.df.IsDir() {
+			mode = 0755
+		}
+	}
+	if fi.df.IsDir() {
+		mode |= iofs.ModeDir
+	}
+	return mode
+}
+
+// ModTime returns the recorded time of the last modification.
+func (fi fileInfo) ModTime() time.Time {
+	return fi.df.ModTime
+}
+
+// IsDir returns true if the file is a directory.
+func (fi fileInfo) IsDir() bool {
+	return fi.df.IsDir()
+}
+
+// Sys returns the underlying DummyFile.
+func (fi fileInfo) Sys() interface{} {
+	return fi.df
+}
+
+// openFile is a file of a simulated filesystem, which has been opened
+// with Open. It implements fs.ReadDirFile for directories.
+type openFile struct {
+	name string
+	info fileInfo
+	// r reads the content of a regular file.
+	r *bytes.Reader
+	// entries are the remaining entries of a directory.
+	entries []iofs.DirEntry
+}
+
+func (f *openFile) Stat() (iofs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *openFile) Read(b []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &iofs.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
+	}
+	if f.r == nil {
+		return 0, &iofs.PathError{Op: "read", Path: f.name, Err: ErrContentUnknown}
+	}
+	return f.r.Read(b)
+}
+
+func (f *openFile) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries of a directory, or all the
+// remaining ones if n <= 0.
+func (f *openFile) ReadDir(n int) ([]iofs.DirEntry, error) {
+	if !f.info.IsDir() {
+		return nil, &iofs.PathError{Op: "readdir", Path: f.name, Err: errors.New("not a directory")}
+	}
+	if n <= 0 || n >= len(f.entries) {
+		entries := f.entries
+		f.entries = nil
+		if n > 0 && len(entries) == 0 {
+			return nil, io.EOF
+		}
+		return entries, nil
+	}
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+// lookupName returns the file with the fs.FS name, which is relative
+// to the root directory "/", or an error for the operation op.
+func (fs Filesystem) lookupName(op, name string) (DummyFile, error) {
+	if !iofs.ValidPath(name) {
+		return DummyFile{}, &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+	df, exists := fs[path.Join("/", name)]
+	if !exists {
+		return DummyFile{}, &iofs.PathError{Op: op, Path: name, Err: iofs.ErrNotExist}
+	}
+	return df, nil
+}
+
+// dirEntries returns the entries of the directory dir sorted by
+// their names.
+func (fs Filesystem) dirEntries(dir string) []iofs.DirEntry {
+	entries := []iofs.DirEntry{}
+	for _, df := range fs.children(dir, false) {
+		entries = append(entries, iofs.FileInfoToDirEntry(fileInfo{df}))
+	}
+	return entries
+}
+
+// Open opens the file name, which is a path relative to the root
+// directory "/" as defined by fs.ValidPath. The content of a regular
+// file can only be read if it is known.
+func (fs Filesystem) Open(name string) (iofs.File, error) {
+	df, err := fs.lookupName("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f := &openFile{name: name, info: fileInfo{df}}
+	if df.IsDir() {
+		f.entries = fs.dirEntries(df.FullPath())
+	} else if df.Content != nil || f.info.Size() == 0 {
+		f.r = bytes.NewReader(df.Content)
+	}
+	return f, nil
+}
+
+// Stat returns the metadata of the file name.
+func (fs Filesystem) Stat(name string) (iofs.FileInfo, error) {
+	df, err := fs.lookupName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{df}, nil
+}
+
+// ReadDir returns the entries of the directory name sorted by their names.
+func (fs Filesystem) ReadDir(name string) ([]iofs.DirEntry, error) {
+	df, err := fs.lookupName("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !df.IsDir() {
+		return nil, &iofs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return fs.dirEntries(df.FullPath()), nil
+}
+
+// ReadFile returns the content of the regular file name, if it is known.
+func (fs Filesystem) ReadFile(name string) ([]byte, error) {
+	df, err := fs.lookupName("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if df.IsDir() {
+		return nil, &iofs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	if df.Content == nil && (fileInfo{df}).Size() > 0 {
+		return nil, &iofs.PathError{Op: "read", Path: name, Err: ErrContentUnknown}
+	}
+	return append([]byte{}, df.Content...), nil
+}
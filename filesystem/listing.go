@@ -0,0 +1,329 @@
+package filesystem
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The formats of the file listings, which can be read by ReadListing
+// in addition to FormatJSON:
+const (
+	// FormatFind is the output of find with absolute paths, one per
+	// line. The lines can also contain the type, the size, the time of
+	// the last modification and the mode of the files, if they have been
+	// printed with: find /path -printf '%y %s %T@ %m %p\n'
+	FormatFind Format = "find"
+	// FormatNUL are absolute paths separated by NUL characters, like the
+	// output of find -print0.
+	FormatNUL Format = "nul"
+	// FormatCSV is a table with a header, which names the columns. The
+	// path column is required, the type, size, mtime and mode columns
+	// are optional.
+	FormatCSV Format = "csv"
+)
+
+// ListingFormats contains the formats, which are supported by ReadListing.
+// FormatJSON is either a snapshot (see WriteSnapshot) or an array of
+// objects with the same fields as the columns of FormatCSV.
+var ListingFormats = []Format{FormatFind, FormatNUL, FormatJSON, FormatCSV}
+
+// listingEntry is a file of a listing, whose type might be unknown.
+type listingEntry struct {
+	df        DummyFile
+	typeKnown bool
+}
+
+// manifestEntry is an element of a JSON manifest.
+type manifestEntry struct {
+	Path  string `json:"path"`
+	Type  string `json:"type"`
+	Size  *int64 `json:"size"`
+	MTime string `json:"mtime"`
+	Mode  string `json:"mode"`
+}
+
+// DetectListingFormat guesses the format of the listing with the file
+// name from its extension and its beginning head.
+func DetectListingFormat(name string, head []byte) Format {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return FormatJSON
+	case ".csv":
+		return FormatCSV
+	}
+	trimmed := bytes.TrimSpace(head)
+	switch {
+	case bytes.IndexByte(head, 0) >= 0:
+		return FormatNUL
+	case len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '['):
+		return FormatJSON
+	}
+	return FormatFind
+}
+
+// ReadListing builds a filesystem from a listing of files in one of the
+// ListingFormats, for example of a tree on another machine. The paths
+// have to be absolute. The entries, whose type is unknown, are regular
+// files, unless the path ends with a slash, another entry is located in
+// them, or they are one of dirs, which are known to be directories
+// (but might be empty). The metadata is only known, if the listing
+// contains the size, the modification time or the mode of a file.
+func ReadListing(r io.Reader, format Format, dirs ...string) (Filesystem, error) {
+	var entries []listingEntry
+	var err error
+	switch format {
+	case FormatFind:
+		entries, err = readFindListing(r)
+	case FormatNUL:
+		entries, err = readNULListing(r)
+	case FormatJSON:
+		var data []byte
+		data, err = io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		trimmed := bytes.TrimSpace(data)
+		if len(trimmed) > 0 && trimmed[0] == '{' {
+			return ReadSnapshot(bytes.NewReader(data), FormatJSON)
+		}
+		entries, err = readJSONListing(data)
+	case FormatCSV:
+		entries, err = readCSVListing(r)
+	default:
+		err = errors.New("unknown listing format: " + string(format))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buildListing(entries, dirs)
+}
+
+// buildListing creates the filesystem containing the entries, whose
+// type is unknown, is a directory if it is a parent of another entry
+// or if it is one of dirs.
+func buildListing(entries []listingEntry, dirs []string) (Filesystem, error) {
+	parents := map[string]bool{}
+	for i := range entries {
+		p := entries[i].df.Path
+		if !path.IsAbs(p) {
+			return nil, errors.New("the paths of a listing have to be absolute: " + p)
+		}
+		if strings.HasSuffix(p, "/") && p != "/" && !entries[i].typeKnown {
+			entries[i].df.IsDirectory = true
+			entries[i].typeKnown = true
+		}
+		p = path.Clean(p)
+		entries[i].df.Path = p
+		for dir := path.Dir(p); !parents[dir] && dir != p; p, dir = dir, path.Dir(dir) {
+			parents[dir] = true
+		}
+	}
+	// The known directories are added last, so that the parents of
+	// the entries in them have been marked up to the root:
+	for _, dir := range dirs {
+		parents[path.Clean(dir)] = true
+	}
+	// The parents have to be created before their entries:
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].df.Path < entries[j].df.Path
+	})
+	fs := Filesystem{}
+	fs.Init()
+	for _, entry := range entries {
+		df := entry.df
+		if !entry.typeKnown {
+			df.IsDirectory = parents[df.Path]
+		}
+		if df.HasMetadata {
+			df.UID, df.GID = -1, -1
+			if df.IsDir() {
+				if df.FileMode == 0 {
+					df.FileMode = 0755
+				}
+				df.FileMode |= os.ModeDir
+			} else if df.FileMode == 0 {
+				df.FileMode = 0644
+			}
+		}
+		err := fs.insert(df)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// parseModTime parses a modification time, which is either a number of
+// seconds since the Unix epoch like %T@ of find, or in RFC 3339 format.
+func parseModTime(s string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		sec := int64(seconds)
+		return time.Unix(sec, int64((seconds-float64(sec))*1e9)).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// setField sets a property of the listing entry from its textual
+// representation. The names of the properties are the ones of the
+// columns of FormatCSV.
+func (entry *listingEntry) setField(name, value string) error {
+	if value == "" {
+		return nil
+	}
+	switch name {
+	case "path":
+		entry.df.Path = value
+	case "type":
+		switch value {
+		case "d", "dir", "directory":
+			entry.df.IsDirectory = true
+		case "f", "file", "l", "link":
+		case "p", "fifo", "s", "socket", "b", "block", "c", "char", "D", "door":
+			// The special files are moved like regular files.
+		default:
+			return errors.New("unknown type " + value)
+		}
+		entry.typeKnown = true
+	case "size":
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.New("invalid size " + value)
+		}
+		entry.df.FileSize = size
+		entry.df.HasMetadata = true
+	case "mtime":
+		modTime, err := parseModTime(value)
+		if err != nil {
+			return errors.New("invalid modification time " + value)
+		}
+		entry.df.ModTime = modTime
+		entry.df.HasMetadata = true
+	case "mode":
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil {
+			return errors.New("invalid mode " + value)
+		}
+		entry.df.FileMode = os.FileMode(mode)
+		entry.df.HasMetadata = true
+	}
+	return nil
+}
+
+// readFindListing reads a listing in FormatFind.
+func readFindListing(r io.Reader) ([]listingEntry, error) {
+	entries := []listingEntry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		entry := listingEntry{df: DummyFile{Path: line}}
+		if !strings.HasPrefix(line, "/") {
+			fields := strings.SplitN(line, " ", 5)
+			if len(fields) != 5 {
+				return nil, fmt.Errorf("listing line %v: expected the format '%%y %%s %%T@ %%m %%p'", n)
+			}
+			for i, name := range []string{"type", "size", "mtime", "mode", "path"} {
+				err := entry.setField(name, fields[i])
+				if err != nil {
+					return nil, fmt.Errorf("listing line %v: %v", n, err)
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// readNULListing reads a listing in FormatNUL.
+func readNULListing(r io.Reader) ([]listingEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	entries := []listingEntry{}
+	for _, p := range strings.Split(string(data), "\x00") {
+		p = strings.TrimSuffix(p, "\n")
+		if p != "" {
+			entries = append(entries, listingEntry{df: DummyFile{Path: p}})
+		}
+	}
+	return entries, nil
+}
+
+// readJSONListing reads a JSON array of manifest entries.
+func readJSONListing(data []byte) ([]listingEntry, error) {
+	var manifest []manifestEntry
+	err := json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	entries := []listingEntry{}
+	for i, m := range manifest {
+		entry := listingEntry{}
+		fields := map[string]string{"path": m.Path, "type": m.Type, "mtime": m.MTime, "mode": m.Mode}
+		if m.Size != nil {
+			fields["size"] = strconv.FormatInt(*m.Size, 10)
+		}
+		for _, name := range []string{"path", "type", "size", "mtime", "mode"} {
+			err := entry.setField(name, fields[name])
+			if err != nil {
+				return nil, fmt.Errorf("listing entry %v: %v", i+1, err)
+			}
+		}
+		if entry.df.Path == "" {
+			return nil, fmt.Errorf("listing entry %v: missing path", i+1)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// readCSVListing reads a listing in FormatCSV.
+func readCSVListing(r io.Reader) ([]listingEntry, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("the listing does not contain a header")
+	}
+	header := records[0]
+	hasPath := false
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+		hasPath = hasPath || header[i] == "path"
+	}
+	if !hasPath {
+		return nil, errors.New("the listing does not contain a path column")
+	}
+	entries := []listingEntry{}
+	for n, record := range records[1:] {
+		entry := listingEntry{}
+		for i, value := range record {
+			err := entry.setField(header[i], value)
+			if err != nil {
+				return nil, fmt.Errorf("listing line %v: %v", n+2, err)
+			}
+		}
+		if entry.df.Path == "" {
+			return nil, fmt.Errorf("listing line %v: missing path", n+2)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
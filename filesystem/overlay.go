@@ -0,0 +1,359 @@
+package filesystem
+
+import (
+	"errors"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/goggle/flatten/osabstraction"
+)
+
+// Overlay is a simulated filesystem, which reads through to a lower
+// filesystem and records all the changes in memory, without touching
+// the lower filesystem. The lower files are only looked up when they
+// are needed, so that a simulation only costs as much as the files it
+// actually touches. Symbolic links are not followed, if the lower
+// filesystem supports it.
+type Overlay struct {
+	// lower is the underlying filesystem, which is mounted at root.
+	lower iofs.FS
+	root  string
+	// upper contains the files, which have been created.
+	upper Filesystem
+	// upperChildren counts the created files per directory.
+	upperChildren map[string]int
+	// deleted contains the paths of the lower files, which have been
+	// removed or moved away.
+	deleted map[string]bool
+	// lookups caches the lower files, which have been looked up. The
+	// value is nil, if the file does not exist.
+	lookups map[string]*DummyFile
+}
+
+// realFS is the real filesystem as an fs.FS, which does not follow
+// symbolic links in Lstat.
+type realFS struct {
+	iofs.FS
+}
+
+// Lstat returns the metadata of the file name relative to "/".
+func (realFS) Lstat(name string) (iofs.FileInfo, error) {
+	return os.Lstat("/" + name)
+}
+
+// lstater is implemented by the filesystems, which can look up
+// symbolic links without following them.
+type lstater interface {
+	Lstat(name string) (iofs.FileInfo, error)
+}
+
+// NewOverlay returns an overlay over the real filesystem without
+// any changes.
+func NewOverlay() *Overlay {
+	return NewFSOverlay(realFS{os.DirFS("/")}, "/")
+}
+
+// NewFSOverlay returns an overlay over the filesystem lower without
+// any changes, for example an embed.FS, an fstest.MapFS or a zip
+// archive. The root of lower is mounted at the absolute path root, so
+// that its files can be flattened like the ones on the real filesystem.
+// A Filesystem must not be changed anymore, once it is used as lower.
+func NewFSOverlay(lower iofs.FS, root string) *Overlay {
+	return &Overlay{
+		lower:         lower,
+		root:          filepath.Clean(root),
+		upper:         Filesystem{},
+		upperChildren: map[string]int{},
+		deleted:       map[string]bool{},
+		lookups:       map[string]*DummyFile{},
+	}
+}
+
+// fromFileInfo returns the dummy file at path p with the metadata fi.
+// If the lower filesystem is a Filesystem itself, its file is returned.
+func fromFileInfo(p string, fi os.FileInfo) DummyFile {
+	if df, ok := fi.Sys().(DummyFile); ok {
+		df.Path = p
+		return df
+	}
+	df := DummyFile{Path: p, IsDirectory: fi.IsDir()}
+	df.setMetadata(fi)
+	return df
+}
+
+// name returns the name of the path p in the lower filesystem, and
+// false if p is not located in its root.
+func (o *Overlay) name(p string) (string, bool) {
+	return relativePath(p, o.root)
+}
+
+// lstat returns the metadata of the file p in the lower filesystem.
+func (o *Overlay) lstat(p string) (iofs.FileInfo, error) {
+	name, ok := o.name(p)
+	if !ok {
+		return nil, &iofs.PathError{Op: "lstat", Path: p, Err: iofs.ErrNotExist}
+	}
+	if l, ok := o.lower.(lstater); ok {
+		return l.Lstat(name)
+	}
+	return iofs.Stat(o.lower, name)
+}
+
+// lowerFile returns the lower file at path p, if it exists and has
+// not been removed.
+func (o *Overlay) lowerFile(p string) (DummyFile, bool) {
+	if o.deleted[p] {
+		return DummyFile{}, false
+	}
+	df, cached := o.lookups[p]
+	if !cached {
+		fi, err := o.lstat(p)
+		if err == nil {
+			f := fromFileInfo(p, fi)
+			df = &f
+		}
+		o.lookups[p] = df
+	}
+	if df == nil {
+		return DummyFile{}, false
+	}
+	return *df, true
+}
+
+// Lookup returns the file at path p together with its metadata, and false
+// if it does not exist.
+func (o *Overlay) Lookup(p string) (DummyFile, bool) {
+	p = filepath.Clean(p)
+	if df, exists := o.upper[p]; exists {
+		return df, true
+	}
+	return o.lowerFile(p)
+}
+
+// IsRegularFile returns true if p is a regular file.
+func (o *Overlay) IsRegularFile(p string) bool {
+	df, exists := o.Lookup(p)
+	return exists && !df.IsDir()
+}
+
+// IsDirectory returns true if p is a directory.
+func (o *Overlay) IsDirectory(p string) bool {
+	df, exists := o.Lookup(p)
+	return exists && df.IsDir()
+}
+
+// Exists returns true if the file p exists.
+func (o *Overlay) Exists(p string) bool {
+	_, exists := o.Lookup(p)
+	return exists
+}
+
+// walk calls fn for all the files in the subtree of dir (without
+// dir itself), including the created ones.
+func (o *Overlay) walk(dir string, fn func(df DummyFile)) error {
+	dir = filepath.Clean(dir)
+	if !o.IsDirectory(dir) {
+		return errors.New(dir + " is not a directory")
+	}
+	if _, lower := o.lowerFile(dir); lower {
+		name, _ := o.name(dir)
+		err := iofs.WalkDir(o.lower, name, func(n string, d iofs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			p := path.Join(o.root, n)
+			if p == dir {
+				return nil
+			}
+			if o.deleted[p] {
+				if d.IsDir() {
+					return iofs.SkipDir
+				}
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			fn(fromFileInfo(p, fi))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for p, df := range o.upper {
+		if inSubtree(p, dir) {
+			fn(df)
+		}
+	}
+	return nil
+}
+
+// GetFiles returns all the regular files located at dir (if includeBaseFiles),
+// and in the subdirectories of dir.
+func (o *Overlay) GetFiles(dir string, includeBaseFiles bool) ([]osabstraction.FileInfo, error) {
+	files := []osabstraction.FileInfo{}
+	dir = filepath.Clean(dir)
+	err := o.walk(dir, func(df DummyFile) {
+		if !df.IsDir() && (includeBaseFiles || df.Directory() != dir) {
+			files = append(files, df)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// GetDirectories returns all the directories in the dir subtree.
+func (o *Overlay) GetDirectories(dir string) ([]osabstraction.FileInfo, error) {
+	dirs := []osabstraction.FileInfo{}
+	err := o.walk(dir, func(df DummyFile) {
+		if df.IsDir() {
+			dirs = append(dirs, df)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// List returns the files and the directories located directly in dir
+// sorted by their names, including the created ones.
+func (o *Overlay) List(dir string) ([]osabstraction.FileInfo, error) {
+	dir = filepath.Clean(dir)
+	if !o.IsDirectory(dir) {
+		return nil, errors.New(dir + " is not a directory")
+	}
+	files := []osabstraction.FileInfo{}
+	if _, lower := o.lowerFile(dir); lower {
+		name, _ := o.name(dir)
+		entries, err := iofs.ReadDir(o.lower, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			p := path.Join(dir, e.Name())
+			if o.deleted[p] {
+				continue
+			}
+			fi, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, fromFileInfo(p, fi))
+		}
+	}
+	for _, df := range o.upper {
+		if df.FullPath() != dir && df.Directory() == dir {
+			files = append(files, df)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+	return files, nil
+}
+
+// isEmpty returns true if the directory dir does not contain any files.
+func (o *Overlay) isEmpty(dir string) (bool, error) {
+	if _, lower := o.lowerFile(dir); lower {
+		name, _ := o.name(dir)
+		entries, err := iofs.ReadDir(o.lower, name)
+		if err != nil {
+			return false, err
+		}
+		for _, e := range entries {
+			if !o.deleted[filepath.Join(dir, e.Name())] {
+				return false, nil
+			}
+		}
+	}
+	return o.upperChildren[dir] == 0, nil
+}
+
+// remove removes the file p, which must exist.
+func (o *Overlay) remove(p string) {
+	if _, exists := o.upper[p]; exists {
+		delete(o.upper, p)
+		o.upperChildren[filepath.Dir(p)]--
+	} else {
+		o.deleted[p] = true
+	}
+}
+
+// create records the file df at path p, whose directory must exist.
+func (o *Overlay) create(p string, df DummyFile) error {
+	if o.Exists(p) {
+		return errors.New(p + " already exists in file system")
+	}
+	if !o.IsDirectory(filepath.Dir(p)) {
+		return errors.New(filepath.Dir(p) + " is not a directory")
+	}
+	df.Path = p
+	o.upper[p] = df
+	o.upperChildren[filepath.Dir(p)]++
+	return nil
+}
+
+// Copy copies the regular file src to dst together with its metadata.
+func (o *Overlay) Copy(src, dst string) error {
+	src, dst = filepath.Clean(src), filepath.Clean(dst)
+	df, exists := o.Lookup(src)
+	if !exists {
+		return errors.New(src + " does not exist in file system")
+	} else if df.IsDir() {
+		return errors.New(src + " is a directory")
+	}
+	return o.create(dst, df)
+}
+
+// Move moves the file or the empty directory src to dst.
+func (o *Overlay) Move(src, dst string) error {
+	src, dst = filepath.Clean(src), filepath.Clean(dst)
+	df, exists := o.Lookup(src)
+	if !exists {
+		return errors.New(src + " does not exist in file system")
+	}
+	if df.IsDir() {
+		empty, err := o.isEmpty(src)
+		if err != nil {
+			return err
+		} else if !empty {
+			return errors.New(src + " is not empty!")
+		}
+	}
+	err := o.create(dst, df)
+	if err != nil {
+		return err
+	}
+	o.remove(src)
+	return nil
+}
+
+// RemoveSubDirectories removes all the directories in the p subtree,
+// which must be empty apart from their subdirectories.
+func (o *Overlay) RemoveSubDirectories(p string) error {
+	p = filepath.Clean(p)
+	dirs, err := o.GetDirectories(p)
+	if err != nil {
+		return err
+	}
+	sort.Sort(byLevel(dirs))
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i].FullPath()
+		empty, err := o.isEmpty(dir)
+		if err != nil {
+			return err
+		} else if !empty {
+			return errors.New(dir + " is not empty!")
+		}
+		o.remove(dir)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package filesystem
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// treeConnectors are the strings, which precede the name of an
+// element in a textual tree, in UTF-8 and in ASCII format.
+var treeConnectors = []string{"├── ", "└── ", "|-- ", "`-- "}
+
+// treeIndents are the strings, which indent an element by one level.
+var treeIndents = []string{"│   ", "    ", "|   "}
+
+// treeReport matches the last line of the output of the tree
+// command, for example "2 directories, 5 files".
+var treeReport = regexp.MustCompile(`^(\d+) director(?:y|ies)(?:, \d+ files?)?$`)
+
+// parseTreeLine returns the depth and the name of the element in
+// a line of a textual tree. The children of the root have depth 1.
+func parseTreeLine(line string) (int, string, error) {
+	depth := 1
+	for {
+		indented := false
+		for _, indent := range treeIndents {
+			if strings.HasPrefix(line, indent) {
+				line = line[len(indent):]
+				depth++
+				indented = true
+				break
+			}
+		}
+		if !indented {
+			break
+		}
+	}
+	for _, connector := range treeConnectors {
+		if strings.HasPrefix(line, connector) {
+			return depth, line[len(connector):], nil
+		}
+	}
+	return 0, "", errors.New("missing connector")
+}
+
+// classifyMarkers are the characters, which the tree command appends
+// to the names with the -F option: "/" to directories, "*" to
+// executable files, "@" to symbolic links, "|" to FIFOs, "=" to
+// sockets and ">" to doors.
+const classifyMarkers = "/*@|=>"
+
+// ParseTree reads a textual tree in the format of Tree.String or
+// of the tree command (also with the --charset=ascii option) and
+// returns a filesystem containing all of its elements. The first
+// line is the path of the root directory. A relative root path
+// like "." is interpreted relative to "/". Elements which have
+// children or whose names end with a "/" become directories, all the
+// others become regular files. If the tree has been printed with
+// the markers of tree -F (see RenderOptions.Classify), which is
+// assumed if all the elements with children end with a "/", the
+// markers are removed from the names. Since empty directories cannot
+// be told apart from files otherwise, an error is returned if the
+// summary line of the tree command reports more directories than
+// found.
+func ParseTree(r io.Reader) (Filesystem, error) {
+	scanner := bufio.NewScanner(r)
+	// The tree command uses non-breaking spaces for the indentation:
+	nbsp := strings.NewReplacer("\u00a0", " ")
+
+	root := ""
+	type entry struct {
+		parent      *entry
+		name        string
+		hasChildren bool
+	}
+	entries := []*entry{}
+	// stack contains the entries of the current branch by depth:
+	stack := []*entry{}
+	reportedDirs := -1
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(nbsp.Replace(scanner.Text()), " \r")
+		if line == "" {
+			continue
+		}
+		if m := treeReport.FindStringSubmatch(line); m != nil {
+			reportedDirs, _ = strconv.Atoi(m[1])
+			continue
+		}
+		if root == "" {
+			root = path.Clean("/" + strings.TrimSuffix(line, "/"))
+			stack = append(stack, &entry{name: root, hasChildren: true})
+			continue
+		}
+		depth, name, err := parseTreeLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineNumber, err)
+		}
+		if depth > len(stack) {
+			return nil, fmt.Errorf("line %v: %v has no parent directory", lineNumber, name)
+		}
+		e := &entry{parent: stack[depth-1], name: name}
+		stack[depth-1].hasChildren = true
+		stack = append(stack[:depth], e)
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if root == "" {
+		return nil, errors.New("empty tree")
+	}
+
+	// The markers of tree -F are only removed, if all the directories,
+	// which are known to be directories, carry them:
+	classified := false
+	for _, e := range entries {
+		if e.hasChildren && !strings.HasSuffix(e.name, "/") {
+			classified = false
+			break
+		}
+		classified = classified || strings.HasSuffix(e.name, "/")
+	}
+	dirs := 0
+	isDir := map[*entry]bool{}
+	for _, e := range entries {
+		isDir[e] = e.hasChildren || strings.HasSuffix(e.name, "/")
+		if isDir[e] {
+			dirs++
+		}
+	}
+	if reportedDirs > dirs {
+		return nil, fmt.Errorf("%v of the %v directories are empty and cannot be told apart from files (use tree -F)", reportedDirs-dirs, reportedDirs)
+	}
+
+	fs := Filesystem{}
+	fs.Init()
+	if root != "/" {
+		if err := fs.MkDir(root); err != nil {
+			return nil, err
+		}
+	}
+	paths := map[*entry]string{stack[0]: root}
+	for _, e := range entries {
+		name := e.name
+		if classified && name != "" && strings.ContainsRune(classifyMarkers, rune(name[len(name)-1])) {
+			name = name[:len(name)-1]
+		} else {
+			name = strings.TrimSuffix(name, "/")
+		}
+		p := path.Join(paths[e.parent], name)
+		paths[e] = p
+		var err error
+		if isDir[e] {
+			err = fs.MkDir(p)
+		} else {
+			err = fs.CreateFile(p)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
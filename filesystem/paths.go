@@ -0,0 +1,34 @@
+package filesystem
+
+import "strings"
+
+// The paths of a subtree are matched by their components, so that the
+// subtree of /data/a contains /data/a/b, but not /data/ab. All the
+// paths have to be clean and absolute.
+
+// subtreePrefix returns the prefix, which all the paths in the subtree
+// of dir share, without dir itself.
+func subtreePrefix(dir string) string {
+	if dir == "/" {
+		return dir
+	}
+	return dir + "/"
+}
+
+// inSubtree returns true if p is located in the subtree of dir, but is
+// not dir itself.
+func inSubtree(p, dir string) bool {
+	return p != dir && strings.HasPrefix(p, subtreePrefix(dir))
+}
+
+// relativePath returns p relative to dir, and false if p is not located
+// in the subtree of dir. The relative path of dir itself is ".".
+func relativePath(p, dir string) (string, bool) {
+	if p == dir {
+		return ".", true
+	}
+	if !inSubtree(p, dir) {
+		return "", false
+	}
+	return strings.TrimPrefix(p, subtreePrefix(dir)), true
+}
@@ -0,0 +1,333 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/goggle/flatten/osabstraction"
+)
+
+// Status describes how an element of a tree is affected by
+// a flattening process.
+type Status int
+
+// The possible states of a tree element:
+const (
+	// StatusUnchanged marks a pre-existing element.
+	StatusUnchanged Status = iota
+	// StatusNew marks an element, which arrives with its old name.
+	StatusNew
+	// StatusRenamed marks an element, which arrives with a new name.
+	StatusRenamed
+	// StatusRemoved marks an element, which is going to be removed.
+	StatusRemoved
+)
+
+// ANSI escape sequences used for the colored rendering:
+const (
+	colorReset = "\x1b[0m"
+	colorDir   = "\x1b[1;34m"
+)
+
+var statusColors = map[Status]string{
+	StatusNew:     "\x1b[32m",
+	StatusRenamed: "\x1b[33m",
+	StatusRemoved: "\x1b[9;31m",
+}
+
+// Decoration contains additional information about a tree element,
+// which is shown when the tree gets rendered.
+type Decoration struct {
+	Status Status
+	// Annotation is printed after the name of the element, for
+	// example the origin of a relocated file.
+	Annotation string
+}
+
+// RenderOptions controls how a tree gets rendered.
+type RenderOptions struct {
+	// Color enables ANSI colors: directories are printed in bold
+	// blue, new elements in green, renamed elements in yellow and
+	// removed elements in red.
+	Color bool
+	// Decorate returns the decoration of an element. It may be nil.
+	Decorate func(fi osabstraction.FileInfo) Decoration
+	// DirStats adds the number of files and, if the elements provide
+	// their size, the total size of every directory.
+	DirStats bool
+	// MaxDepth limits the depth of the rendered elements. The children
+	// of the root element have depth 1. 0 means no limit.
+	MaxDepth int
+	// MaxChildren limits the number of rendered children per directory.
+	// The remaining children are collapsed into a single line. 0 means
+	// no limit.
+	MaxChildren int
+	// Pattern restricts the rendered files to the ones whose names
+	// match the shell pattern (see filepath.Match). Directories are
+	// only rendered if they contain matching files.
+	Pattern string
+	// DirsOnly restricts the rendered elements to directories.
+	DirsOnly bool
+	// Classify appends the markers of tree -F to the names: "/" to
+	// directories and "*" to executable files, whose mode is known.
+	// Such trees can be parsed with ParseTree without losing the
+	// empty directories.
+	Classify bool
+}
+
+// sizer is implemented by the file types, which know their size.
+type sizer interface {
+	Size() int64
+}
+
+// knownSize returns the size of fi, and false if it is unknown.
+func knownSize(fi osabstraction.FileInfo) (int64, bool) {
+	if df, ok := fi.(DummyFile); ok && !df.HasMetadata {
+		return 0, false
+	}
+	if sz, ok := fi.(sizer); ok {
+		return sz.Size(), true
+	}
+	return 0, false
+}
+
+// dirStats returns the number of regular files in the tree t and
+// their total size. known is false, if the size of any file is unknown.
+func (t *Tree) dirStats() (files int, size int64, known bool) {
+	known = true
+	for _, child := range t.children {
+		if child.node.IsDir() {
+			f, s, k := child.dirStats()
+			files += f
+			size += s
+			known = known && k
+			continue
+		}
+		files++
+		sz, ok := knownSize(child.node)
+		size += sz
+		known = known && ok
+	}
+	return files, size, known
+}
+
+// formatSize formats a number of bytes in a human readable way.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%v B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// matches returns true if t is a file whose name matches the shell
+// pattern, or a directory which contains such a file.
+func (t *Tree) matches(pattern string) bool {
+	if !t.node.IsDir() {
+		ok, _ := filepath.Match(pattern, t.node.Name())
+		return ok
+	}
+	for _, child := range t.children {
+		if child.matches(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleChildren returns the children of t, which are rendered
+// according to the filters in opts.
+func (t *Tree) visibleChildren(opts RenderOptions) []*Tree {
+	if opts.Pattern == "" && !opts.DirsOnly {
+		return t.children
+	}
+	visible := make([]*Tree, 0, len(t.children))
+	for _, child := range t.children {
+		if opts.DirsOnly && !child.node.IsDir() {
+			continue
+		}
+		if opts.Pattern != "" && !child.matches(opts.Pattern) {
+			continue
+		}
+		visible = append(visible, child)
+	}
+	return visible
+}
+
+// renderedChildren returns the children of t, which are rendered
+// at the given depth according to opts, and the ones which get
+// collapsed because of opts.MaxChildren.
+func (t *Tree) renderedChildren(opts RenderOptions, depth int) (shown, hidden []*Tree) {
+	if opts.MaxDepth > 0 && depth > opts.MaxDepth {
+		return nil, nil
+	}
+	shown = t.visibleChildren(opts)
+	if opts.MaxChildren > 0 && len(shown) > opts.MaxChildren {
+		return shown[:opts.MaxChildren], shown[opts.MaxChildren:]
+	}
+	return shown, nil
+}
+
+// FormatCount formats n with thousands separators, like 12,345.
+func FormatCount(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// collapsedLabel describes the children, which are not rendered
+// because of the MaxChildren option.
+func collapsedLabel(hidden []*Tree) string {
+	dirs := 0
+	for _, h := range hidden {
+		if h.node.IsDir() {
+			dirs++
+		}
+	}
+	kind := "entries"
+	if dirs == 0 {
+		kind = "files"
+	} else if dirs == len(hidden) {
+		kind = "directories"
+	}
+	if len(hidden) == 1 {
+		kind = map[string]string{"files": "file", "directories": "directory"}[kind]
+	}
+	return "… " + FormatCount(len(hidden)) + " more " + kind
+}
+
+// decoration returns the decoration of the element t.
+func (t *Tree) decoration(opts RenderOptions) Decoration {
+	if opts.Decorate == nil {
+		return Decoration{}
+	}
+	return opts.Decorate(t.node)
+}
+
+// details returns the directory statistics and the annotation of
+// the element t, which are printed after its name.
+func (t *Tree) details(opts RenderOptions) string {
+	details := ""
+	if opts.DirStats && t.node.IsDir() {
+		files, size, known := t.dirStats()
+		stats := fmt.Sprintf("%v files", files)
+		if files == 1 {
+			stats = "1 file"
+		}
+		if known {
+			stats += ", " + formatSize(size)
+		}
+		details += " [" + stats + "]"
+	}
+	if dec := t.decoration(opts); dec.Annotation != "" {
+		details += "  " + dec.Annotation
+	}
+	return details
+}
+
+// classifier returns the marker of tree -F for the file fi, which is
+// "/" for directories and "*" for executable files.
+func classifier(fi osabstraction.FileInfo) string {
+	if fi.IsDir() {
+		return "/"
+	}
+	var mode os.FileMode
+	switch f := fi.(type) {
+	case DummyFile:
+		if !f.HasMetadata {
+			return ""
+		}
+		mode = f.FileMode
+	case osabstraction.File:
+		info, err := os.Lstat(f.FullPath())
+		if err != nil {
+			return ""
+		}
+		mode = info.Mode()
+	}
+	if mode.IsRegular() && mode&0111 != 0 {
+		return "*"
+	}
+	return ""
+}
+
+// label returns the rendered name of the element t, including its
+// color and annotations.
+func (t *Tree) label(name string, opts RenderOptions) string {
+	if opts.Color {
+		if c, ok := statusColors[t.decoration(opts).Status]; ok {
+			name = c + name + colorReset
+		} else if t.node.IsDir() {
+			name = colorDir + name + colorReset
+		}
+	}
+	return name + t.details(opts)
+}
+
+// Line is a single line of a rendered tree.
+type Line struct {
+	// Prefix contains the indentation and the connector of the line.
+	Prefix string
+	// Label is the name of the element including its details.
+	Label string
+	// Tree is the element shown in the line. It is nil for the
+	// line, which summarizes the collapsed children of a directory.
+	Tree *Tree
+}
+
+// String returns the line as it is printed by Render.
+func (l Line) String() string {
+	return l.Prefix + l.Label
+}
+
+// Lines renders the tree in the same way as Render, but returns the
+// individual lines together with the elements they show.
+func (t *Tree) Lines(opts RenderOptions) []Line {
+	lines := []Line{{Label: t.label(t.node.FullPath(), opts), Tree: t}}
+
+	// renderChildren renders the visible children of t at the given
+	// depth, and collapses the ones exceeding opts.MaxChildren.
+	var renderChildren func(t *Tree, prefix string, depth int)
+	renderChildren = func(t *Tree, prefix string, depth int) {
+		children, hidden := t.renderedChildren(opts, depth)
+		for i, child := range children {
+			last := i == len(children)-1 && len(hidden) == 0
+			connector, childPrefix := "├── ", prefix+"│   "
+			if last {
+				connector, childPrefix = "└── ", prefix+"    "
+			}
+			name := child.node.Name()
+			if opts.Classify {
+				name += classifier(child.node)
+			}
+			lines = append(lines, Line{Prefix: prefix + connector, Label: child.label(name, opts), Tree: child})
+			renderChildren(child, childPrefix, depth+1)
+		}
+		if len(hidden) > 0 {
+			lines = append(lines, Line{Prefix: prefix + "└── ", Label: collapsedLabel(hidden)})
+		}
+	}
+
+	renderChildren(t, "", 1)
+	return lines
+}
+
+// Render renders the tree in the same way as the tree command does,
+// using the options opts.
+func (t Tree) Render(opts RenderOptions) string {
+	var sb strings.Builder
+	for _, line := range t.Lines(opts) {
+		sb.WriteString(line.String() + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,203 @@
+package filesystem
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FormatYAML is a YAML document. It is only supported by snapshots,
+// which can be written in FormatJSON as well.
+const FormatYAML Format = "yaml"
+
+// snapshot is the serialized state of a Filesystem.
+type snapshot struct {
+	Entries []snapshotEntry `json:"entries" yaml:"entries"`
+}
+
+// snapshotEntry is the serialized form of a DummyFile. The content is
+// base64 encoded and the metadata is omitted, if it is unknown.
+type snapshotEntry struct {
+	Path     string            `json:"path" yaml:"path"`
+	Type     string            `json:"type" yaml:"type"`
+	Metadata *snapshotMetadata `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Content  *string           `json:"content,omitempty" yaml:"content,omitempty"`
+	Hash     string            `json:"hash,omitempty" yaml:"hash,omitempty"`
+}
+
+// snapshotMetadata is the serialized metadata of a DummyFile. The mode
+// is an octal number without the directory bit, since the type of the
+// file is stored separately.
+type snapshotMetadata struct {
+	Size    int64  `json:"size" yaml:"size"`
+	Mode    string `json:"mode" yaml:"mode"`
+	ModTime string `json:"mtime" yaml:"mtime"`
+	UID     int    `json:"uid" yaml:"uid"`
+	GID     int    `json:"gid" yaml:"gid"`
+}
+
+// Entry types of a snapshot, which are the same as in the JSON export:
+const (
+	snapshotFile      = "file"
+	snapshotDirectory = "directory"
+)
+
+// toSnapshotEntry serializes df.
+func toSnapshotEntry(df DummyFile) snapshotEntry {
+	entry := snapshotEntry{Path: df.FullPath(), Type: snapshotFile, Hash: df.Hash}
+	if df.IsDir() {
+		entry.Type = snapshotDirectory
+	}
+	if df.HasMetadata {
+		entry.Metadata = &snapshotMetadata{
+			Size:    df.FileSize,
+			Mode:    fmt.Sprintf("%#o", uint32(df.FileMode&^os.ModeDir)),
+			ModTime: df.ModTime.Format(time.RFC3339Nano),
+			UID:     df.UID,
+			GID:     df.GID,
+		}
+	}
+	if df.Content != nil {
+		content := base64.StdEncoding.EncodeToString(df.Content)
+		entry.Content = &content
+	}
+	return entry
+}
+
+// dummyFile deserializes the entry.
+func (entry snapshotEntry) dummyFile() (DummyFile, error) {
+	df := DummyFile{Path: entry.Path, Hash: entry.Hash}
+	switch entry.Type {
+	case snapshotFile:
+	case snapshotDirectory:
+		df.IsDirectory = true
+	default:
+		return df, errors.New(entry.Path + ": unknown type " + entry.Type)
+	}
+	if entry.Metadata != nil {
+		mode, err := strconv.ParseUint(entry.Metadata.Mode, 0, 32)
+		if err != nil {
+			return df, errors.New(entry.Path + ": invalid mode " + entry.Metadata.Mode)
+		}
+		modTime, err := time.Parse(time.RFC3339Nano, entry.Metadata.ModTime)
+		if err != nil {
+			return df, errors.New(entry.Path + ": invalid modification time " + entry.Metadata.ModTime)
+		}
+		df.HasMetadata = true
+		df.FileSize = entry.Metadata.Size
+		df.FileMode = os.FileMode(mode)
+		if df.IsDirectory {
+			df.FileMode |= os.ModeDir
+		}
+		df.ModTime = modTime
+		df.UID, df.GID = entry.Metadata.UID, entry.Metadata.GID
+	}
+	if entry.Content != nil {
+		content, err := base64.StdEncoding.DecodeString(*entry.Content)
+		if err != nil {
+			return df, errors.New(entry.Path + ": invalid content: " + err.Error())
+		}
+		df.Content = content
+	}
+	return df, nil
+}
+
+// WriteSnapshot writes the state of the filesystem including the
+// metadata and the contents of its files to w, either in FormatJSON or
+// in FormatYAML. The entries are sorted by their paths, so that equal
+// filesystems result in equal snapshots.
+func (fs Filesystem) WriteSnapshot(w io.Writer, format Format) error {
+	paths := make([]string, 0, len(fs))
+	for p := range fs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	s := snapshot{Entries: make([]snapshotEntry, 0, len(paths))}
+	for _, p := range paths {
+		s.Entries = append(s.Entries, toSnapshotEntry(fs[p]))
+	}
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case FormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		err := enc.Encode(s)
+		if err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	return errors.New("unknown snapshot format: " + string(format))
+}
+
+// ReadSnapshot reads a filesystem from a snapshot in FormatJSON or in
+// FormatYAML, which has been written by WriteSnapshot. Missing parent
+// directories are created without metadata.
+func ReadSnapshot(r io.Reader, format Format) (Filesystem, error) {
+	var s snapshot
+	var err error
+	switch format {
+	case FormatJSON:
+		err = json.NewDecoder(r).Decode(&s)
+	case FormatYAML:
+		err = yaml.NewDecoder(r).Decode(&s)
+	default:
+		err = errors.New("unknown snapshot format: " + string(format))
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The parents have to be created before their entries:
+	sort.Slice(s.Entries, func(i, j int) bool {
+		return s.Entries[i].Path < s.Entries[j].Path
+	})
+	fs := Filesystem{}
+	fs.Init()
+	for _, entry := range s.Entries {
+		df, err := entry.dummyFile()
+		if err != nil {
+			return nil, err
+		}
+		if !path.IsAbs(df.Path) || path.Clean(df.Path) != df.Path {
+			return nil, errors.New("invalid path: " + df.Path)
+		}
+		err = fs.insert(df)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// insert adds the file df together with its metadata and the missing
+// parent directories to the filesystem. The root directory can only be
+// updated.
+func (fs Filesystem) insert(df DummyFile) error {
+	var err error
+	if df.Path != "/" {
+		if df.IsDir() {
+			err = fs.MkDir(df.Path)
+		} else {
+			err = fs.CreateFile(df.Path)
+		}
+	} else if !df.IsDir() {
+		err = errors.New("/ is not a directory")
+	}
+	if err != nil {
+		return err
+	}
+	fs[df.Path] = df
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package filesystem
+
+// freeSpace returns -1, since determining the free space of a device
+// is not supported on this operating system.
+func freeSpace(p string) (int64, error) {
+	return -1, nil
+}
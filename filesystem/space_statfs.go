@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package filesystem
+
+import (
+	"os"
+	"syscall"
+)
+
+// freeSpace returns the number of bytes, which are available to an
+// unprivileged user on the device containing the path p.
+func freeSpace(p string) (int64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(p, &st)
+	if err != nil {
+		return 0, &os.PathError{Op: "statfs", Path: p, Err: err}
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
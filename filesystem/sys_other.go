@@ -0,0 +1,11 @@
+//go:build !unix
+
+package filesystem
+
+import "os"
+
+// owner returns -1 for the IDs of the owner and the group, since
+// they are not supported by the operating system.
+func owner(fi os.FileInfo) (uid, gid int) {
+	return -1, -1
+}
@@ -1,7 +1,9 @@
 package filesystem
 
 import (
+	"encoding/json"
 	"errors"
+	"path"
 	"sort"
 	"strings"
 
@@ -12,6 +14,8 @@ import (
 type Tree struct {
 	node     osabstraction.FileInfo
 	children []*Tree
+	// index maps the names of the children to their subtrees.
+	index map[string]*Tree
 }
 
 // Init initializes the filesystem tree with a root node.
@@ -33,38 +37,135 @@ func (t *Tree) InsertSuccessor(fi osabstraction.FileInfo) error {
 		return errors.New("tree has not been initialized")
 	}
 	rootFullpath := t.node.FullPath()
-	if rootFullpath != "/" {
-		rootFullpath += "/"
-	}
-
-	if !strings.HasPrefix(fi.FullPath(), rootFullpath) {
+	relPath, contained := relativePath(fi.FullPath(), rootFullpath)
+	if !contained || relPath == "." {
 		return errors.New(fi.FullPath() + " is not contained in " + rootFullpath)
 	}
 
-	relativePath := strings.TrimPrefix(fi.FullPath(), rootFullpath)
-	elems := strings.Split(relativePath, "/")
+	elems := strings.Split(relPath, "/")
 	curr := t
 	for _, elem := range elems[:len(elems)-1] {
-		found := false
-		for _, n := range curr.children {
-			if n.node.Name() == elem {
-				curr = n
-				found = true
-				break
+		n, found := curr.index[elem]
+		if !found {
+			return errors.New("Could not add " + fi.FullPath() + " to tree. Could only proceed up to element " + curr.node.FullPath())
+		}
+		curr = n
+	}
+	_, err := curr.addChild(fi)
+	return err
+}
+
+// addChild adds fi as a direct child of t and returns its subtree.
+func (t *Tree) addChild(fi osabstraction.FileInfo) (*Tree, error) {
+	if _, exists := t.index[fi.Name()]; exists {
+		return nil, errors.New(fi.FullPath() + " already exists in tree")
+	}
+	if t.index == nil {
+		t.index = map[string]*Tree{}
+	}
+	newNode := Tree{node: fi, children: make([]*Tree, 0)}
+	t.children = append(t.children, &newNode)
+	t.index[fi.Name()] = &newNode
+	return &newNode, nil
+}
+
+// Node returns the file at the root of the tree t.
+func (t *Tree) Node() osabstraction.FileInfo {
+	return t.node
+}
+
+// Children returns the subtrees of the direct children of t,
+// sorted by their names.
+func (t *Tree) Children() []*Tree {
+	return append([]*Tree(nil), t.children...)
+}
+
+// SkipSubtree can be returned by a WalkFunc to skip the children
+// of the current element.
+var SkipSubtree = errors.New("skip this subtree")
+
+// WalkFunc is called by Walk for every element of a tree. depth is
+// the depth of the element, the root element has depth 0.
+type WalkFunc func(t *Tree, depth int) error
+
+// Walk calls fn for every element of the tree t in depth-first
+// order, with every directory being visited before its children.
+// If fn returns SkipSubtree, the children of the current element
+// are skipped. Any other error stops the walk and gets returned.
+func (t *Tree) Walk(fn WalkFunc) error {
+	var walk func(t *Tree, depth int) error
+	walk = func(t *Tree, depth int) error {
+		err := fn(t, depth)
+		if err == SkipSubtree {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, child := range t.children {
+			err := walk(child, depth+1)
+			if err != nil {
+				return err
 			}
 		}
+		return nil
+	}
+	return walk(t, 0)
+}
+
+// Find returns the subtree of the element located at p, which is
+// either an absolute path or a path relative to the root of t.
+// If there is no such element, nil gets returned.
+func (t *Tree) Find(p string) *Tree {
+	if t.node == nil {
+		return nil
+	}
+	rootPath := t.node.FullPath()
+	p = path.Clean(p)
+	if path.IsAbs(p) {
+		var contained bool
+		p, contained = relativePath(p, rootPath)
+		if !contained {
+			return nil
+		}
+	}
+	if p == "." {
+		return t
+	}
+	curr := t
+	for _, elem := range strings.Split(p, "/") {
+		n, found := curr.index[elem]
 		if !found {
-			return errors.New("Could not add " + fi.FullPath() + " to tree. Could only proceed up to element " + curr.node.FullPath())
+			return nil
 		}
+		curr = n
 	}
-	for _, elem := range curr.children {
-		if elem.node.Name() == fi.Name() {
-			return errors.New(fi.FullPath() + " already exists in tree")
+	return curr
+}
+
+// Depth returns the depth of the deepest element in the tree t.
+// A tree without children has depth 0.
+func (t *Tree) Depth() int {
+	depth := 0
+	for _, child := range t.children {
+		if d := child.Depth() + 1; d > depth {
+			depth = d
 		}
 	}
-	newNode := Tree{node: fi, children: make([]*Tree, 0)}
-	curr.children = append(curr.children, &newNode)
-	return nil
+	return depth
+}
+
+// FileCount returns the number of regular files in the tree t.
+func (t *Tree) FileCount() int {
+	files, _, _ := t.dirStats()
+	return files
+}
+
+// TotalSize returns the total size of the regular files in the
+// tree t. known is false, if the size of any file is unknown, since
+// the file type does not provide it or it has not been recorded.
+func (t *Tree) TotalSize() (size int64, known bool) {
+	_, size, known = t.dirStats()
+	return size, known
 }
 
 // Count counts the elements in the tree.
@@ -111,7 +212,8 @@ func (t *Tree) Sort() {
 }
 
 // Create automatically creates a filesystem tree only giving an abstract
-// osabstraction.FileInfo root element.
+// osabstraction.FileInfo root element. It takes O(n log n) time for
+// n elements.
 func (t *Tree) Create(root osabstraction.FileInfo, osw osabstraction.OSWrapper) error {
 	err := t.Init(root)
 	if err != nil {
@@ -135,84 +237,68 @@ func (t *Tree) Create(root osabstraction.FileInfo, osw osabstraction.OSWrapper)
 	files = append(regularFiles, files...)
 	files = append(directories, files...)
 
-	// NOTE: The following code for inserting files into the tree is
-	// totally inefficient...
-	// It works as follows: We iterate through the files in the
-	// []FileInfo slice and try to insert these files into the tree
-	// as long as we succeed. We can only insert a file with the
-	// InsertSuccessor method, if the parrent directory of the file
-	// has already been inserted to the tree.
-	for {
-		changed := false
-		nextFiles := []osabstraction.FileInfo{}
-		for _, fi := range files {
-			err := t.InsertSuccessor(fi)
-			if err == nil {
-				changed = true
-			} else {
-				nextFiles = append(nextFiles, fi)
-			}
+	// Sorting the files by their paths guarantees, that every
+	// directory gets inserted before its content, and that the
+	// children of every directory are inserted in the order of
+	// their names.
+	type entry struct {
+		path string
+		fi   osabstraction.FileInfo
+	}
+	entries := make([]entry, len(files))
+	for i, fi := range files {
+		entries[i] = entry{path: fi.FullPath(), fi: fi}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].path < entries[j].path
+	})
+	dirs := map[string]*Tree{rootPath: t}
+	for _, e := range entries {
+		parent, ok := dirs[path.Dir(e.path)]
+		if !ok {
+			return errors.New("could not insert " + e.path + " into the tree")
 		}
-		if !changed {
-			return errors.New("could not insert all the files into the tree")
+		child, err := parent.addChild(e.fi)
+		if err != nil {
+			return err
 		}
-		if len(nextFiles) == 0 {
-			break
+		if e.fi.IsDir() {
+			dirs[e.path] = child
 		}
-		files = nextFiles
 	}
-	t.Sort()
 	return nil
 }
 
+// String renders the tree in the same way as the tree command does.
 func (t Tree) String() string {
-	rootLine := t.node.FullPath() + "\n"
-	output := rootLine
-
-	var elemInList func(list []int, elem int) bool
-	elemInList = func(list []int, elem int) bool {
-		for _, l := range list {
-			if l == elem {
-				return true
-			}
-		}
-		return false
-	}
+	return t.Render(RenderOptions{})
+}
 
-	var traverse func(t *Tree, level int, leaveBlankIndex []int, last bool)
-	traverse = func(t *Tree, level int, leaveBlankIndex []int, last bool) {
-		for i := 0; i < level-1; i++ {
-			if elemInList(leaveBlankIndex, i) {
-				output += "    "
-			} else {
-				output += "│   "
-			}
-		}
-		if !last {
-			output += "├── " + t.node.Name() + "\n"
-		} else {
-			output += "└── " + t.node.Name() + "\n"
-			if !elemInList(leaveBlankIndex, level-1) {
-				leaveBlankIndex = append(leaveBlankIndex, level-1)
-			}
-		}
-		for i, child := range t.children {
-			if i == 0 {
-			}
-			if i != len(t.children)-1 {
-				traverse(child, level+1, leaveBlankIndex, false)
-			} else {
-				traverse(child, level+1, leaveBlankIndex, true)
-			}
-		}
+// jsonTree is the JSON representation of a tree element. It uses
+// the same structure as the JSON output of the tree command.
+type jsonTree struct {
+	Type     string     `json:"type"`
+	Name     string     `json:"name"`
+	Contents []jsonTree `json:"contents,omitempty"`
+}
+
+func (t *Tree) toJSONTree(name string) jsonTree {
+	jt := jsonTree{Type: "file", Name: name}
+	if t.node.IsDir() {
+		jt.Type = "directory"
+	}
+	for _, child := range t.children {
+		jt.Contents = append(jt.Contents, child.toJSONTree(child.node.Name()))
 	}
+	return jt
+}
 
-	for i, child := range t.children {
-		if i != len(t.children)-1 {
-			traverse(child, 1, []int{}, false)
-		} else {
-			traverse(child, 1, []int{}, true)
-		}
+// MarshalJSON encodes the tree as nested JSON objects with the
+// fields "type" ("directory" or "file"), "name" and "contents".
+// The root element is named by its full path.
+func (t Tree) MarshalJSON() ([]byte, error) {
+	if t.node == nil {
+		return nil, errors.New("tree has not been initialized")
 	}
-	return output
+	return json.Marshal(t.toJSONTree(t.node.FullPath()))
 }
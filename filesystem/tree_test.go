@@ -1,8 +1,13 @@
 package filesystem
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+
+	"github.com/goggle/flatten/osabstraction"
 )
 
 func TestTreeOne(t *testing.T) {
@@ -98,3 +103,502 @@ func TestTreeTwo(t *testing.T) {
 		t.Errorf("TreeTestOne: Expected %v, got %v", expectedTreeString, tree)
 	}
 }
+
+func TestTreeJSON(t *testing.T) {
+	fs := Filesystem{}
+	fs.Init()
+	fs.MkDir("/tmp/flatten/empty")
+	fs.CreateFile("/tmp/flatten/main.go")
+	fs.CreateFile("/tmp/readme.md")
+
+	tree := Tree{}
+	err := tree.Create(fs["/tmp"], fs)
+	if err != nil {
+		t.Errorf("TestTreeJSON: No error expected, got %v", err)
+	}
+	b, err := json.Marshal(tree)
+	if err != nil {
+		t.Errorf("TestTreeJSON: No error expected, got %v", err)
+	}
+	expected := `{"type":"directory","name":"/tmp","contents":[` +
+		`{"type":"directory","name":"flatten","contents":[` +
+		`{"type":"directory","name":"empty"},` +
+		`{"type":"file","name":"main.go"}]},` +
+		`{"type":"file","name":"readme.md"}]}`
+	if string(b) != expected {
+		t.Errorf("TestTreeJSON: Expected %v, got %v", expected, string(b))
+	}
+}
+
+func TestTreeRender(t *testing.T) {
+	fs := Filesystem{}
+	fs.Init()
+	fs.MkDir("/tmp/a/b")
+	fs.CreateFile("/tmp/a/b/hello.txt")
+	fs.CreateFile("/tmp/a/world.txt")
+	fs.CreateFile("/tmp/new.txt")
+
+	tree := Tree{}
+	err := tree.Create(fs["/tmp"], fs)
+	if err != nil {
+		t.Errorf("TestTreeRender: No error expected, got %v", err)
+	}
+	decorate := func(fi osabstraction.FileInfo) Decoration {
+		switch fi.FullPath() {
+		case "/tmp/new.txt":
+			return Decoration{Status: StatusNew, Annotation: "← a/new.txt"}
+		case "/tmp/a/b":
+			return Decoration{Status: StatusRemoved}
+		}
+		return Decoration{}
+	}
+
+	expected := `/tmp [3 files]
+├── a [2 files]
+│   ├── b [1 file]
+│   │   └── hello.txt
+│   └── world.txt
+└── new.txt  ← a/new.txt
+`
+	result := tree.Render(RenderOptions{Decorate: decorate, DirStats: true})
+	if result != expected {
+		t.Errorf("TestTreeRender: Expected %v, got %v", expected, result)
+	}
+
+	expected = "\x1b[1;34m/tmp\x1b[0m\n" +
+		"├── \x1b[1;34ma\x1b[0m\n" +
+		"│   ├── \x1b[9;31mb\x1b[0m\n" +
+		"│   │   └── hello.txt\n" +
+		"│   └── world.txt\n" +
+		"└── \x1b[32mnew.txt\x1b[0m  ← a/new.txt\n"
+	result = tree.Render(RenderOptions{Decorate: decorate, Color: true})
+	if result != expected {
+		t.Errorf("TestTreeRender: Expected %q, got %q", expected, result)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	sizes := []int64{0, 1023, 1024, 1536, 5 * 1024 * 1024}
+	expected := []string{"0 B", "1023 B", "1.0 KiB", "1.5 KiB", "5.0 MiB"}
+	for i, size := range sizes {
+		if result := formatSize(size); result != expected[i] {
+			t.Errorf("formatSize(%v): Expected %v, got %v", size, expected[i], result)
+		}
+	}
+}
+
+func TestTreeRenderLimits(t *testing.T) {
+	fs := Filesystem{}
+	fs.Init()
+	fs.MkDir("/tmp/a/b")
+	fs.MkDir("/tmp/empty")
+	fs.CreateFile("/tmp/a/b/deep.txt")
+	fs.CreateFile("/tmp/a/image.png")
+	for i := 1; i <= 1200; i++ {
+		fs.CreateFile(fmt.Sprintf("/tmp/file%04v.txt", i))
+	}
+
+	tree := Tree{}
+	err := tree.Create(fs["/tmp"], fs)
+	if err != nil {
+		t.Errorf("TestTreeRenderLimits: No error expected, got %v", err)
+	}
+
+	expected := `/tmp
+├── a
+│   ├── b
+│   └── image.png
+├── empty
+├── file0001.txt
+└── … 1,199 more files
+`
+	result := tree.Render(RenderOptions{MaxDepth: 2, MaxChildren: 3})
+	if result != expected {
+		t.Errorf("TestTreeRenderLimits: Expected %v, got %v", expected, result)
+	}
+
+	expected = `/tmp
+└── a
+    └── b
+        └── deep.txt
+`
+	result = tree.Render(RenderOptions{Pattern: "*p.txt"})
+	if result != expected {
+		t.Errorf("TestTreeRenderLimits: Expected %v, got %v", expected, result)
+	}
+
+	expected = `/tmp
+├── a
+│   └── b
+└── empty
+`
+	result = tree.Render(RenderOptions{DirsOnly: true})
+	if result != expected {
+		t.Errorf("TestTreeRenderLimits: Expected %v, got %v", expected, result)
+	}
+}
+
+func TestTreeLines(t *testing.T) {
+	fs := Filesystem{}
+	fs.Init()
+	fs.MkDir("/tmp/a")
+	fs.CreateFile("/tmp/a/hello.txt")
+	fs.CreateFile("/tmp/b.txt")
+	fs.CreateFile("/tmp/c.txt")
+
+	tree := Tree{}
+	err := tree.Create(fs["/tmp"], fs)
+	if err != nil {
+		t.Errorf("TestTreeLines: No error expected, got %v", err)
+	}
+
+	lines := tree.Lines(RenderOptions{MaxChildren: 2})
+	expected := []struct {
+		prefix, label, path string
+	}{
+		{"", "/tmp", "/tmp"},
+		{"├── ", "a", "/tmp/a"},
+		{"│   └── ", "hello.txt", "/tmp/a/hello.txt"},
+		{"├── ", "b.txt", "/tmp/b.txt"},
+		{"└── ", "… 1 more file", ""},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("TestTreeLines: Expected %v lines, got %v", len(expected), len(lines))
+	}
+	for i, e := range expected {
+		l := lines[i]
+		path := ""
+		if l.Tree != nil {
+			path = l.Tree.Node().FullPath()
+		}
+		if l.Prefix != e.prefix || l.Label != e.label || path != e.path {
+			t.Errorf("TestTreeLines: Expected line %v to be %q %q (%v), got %q %q (%v)", i, e.prefix, e.label, e.path, l.Prefix, l.Label, path)
+		}
+	}
+}
+
+func TestTreeExport(t *testing.T) {
+	fs := Filesystem{}
+	fs.Init()
+	fs.MkDir("/tmp/a")
+	fs.CreateFile("/tmp/a/hello_world.txt")
+	fs.CreateFile("/tmp/b.txt")
+	fs.CreateFile("/tmp/c.txt")
+
+	tree := Tree{}
+	err := tree.Create(fs["/tmp"], fs)
+	if err != nil {
+		t.Errorf("TestTreeExport: No error expected, got %v", err)
+	}
+
+	expected := map[Format]string{
+		FormatJSON: `[{"type":"directory","name":"/tmp","contents":[` +
+			`{"type":"directory","name":"a","contents":[{"type":"file","name":"hello_world.txt"}]},` +
+			`{"type":"file","name":"b.txt"}]},` +
+			`{"type":"report","directories":1,"files":2}]` + "\n",
+		FormatMarkdown: `- **/tmp/**
+  - **a/**
+    - hello\_world.txt
+  - b.txt
+  - *… 1 more file*
+`,
+		FormatDOT: `digraph tree {
+	rankdir=LR;
+	node [shape=box];
+	n0 [label="/tmp", shape=folder];
+	n1 [label="a", shape=folder];
+	n2 [label="hello_world.txt", shape=note];
+	n1 -> n2;
+	n0 -> n1;
+	n3 [label="b.txt", shape=note];
+	n0 -> n3;
+	n4 [label="… 1 more file", shape=plaintext];
+	n0 -> n4;
+}
+`,
+	}
+	opts := RenderOptions{MaxChildren: 2}
+	for format, exp := range expected {
+		var sb strings.Builder
+		err := tree.Export(&sb, format, opts)
+		if err != nil {
+			t.Errorf("TestTreeExport(%v): No error expected, got %v", format, err)
+		}
+		if sb.String() != exp {
+			t.Errorf("TestTreeExport(%v): Expected %v, got %v", format, exp, sb.String())
+		}
+	}
+
+	var sb strings.Builder
+	err = tree.Export(&sb, FormatHTML, opts)
+	if err != nil {
+		t.Errorf("TestTreeExport(html): No error expected, got %v", err)
+	}
+	for _, part := range []string{"<!DOCTYPE html>", "<summary>a</summary>", "<li>hello_world.txt</li>", `<li class="collapsed">… 1 more file</li>`} {
+		if !strings.Contains(sb.String(), part) {
+			t.Errorf("TestTreeExport(html): Expected %v to be contained in %v", part, sb.String())
+		}
+	}
+
+	err = tree.Export(&sb, Format("xml"), opts)
+	if err == nil {
+		t.Errorf("TestTreeExport(xml): Error expected, got nil")
+	}
+
+	// Only the quotes and the backslashes are escaped in DOT:
+	fs.CreateFile("/tmp/a/say \"hi\"\\ä\u200b.txt")
+	tree = Tree{}
+	err = tree.Create(fs["/tmp/a"], fs)
+	if err != nil {
+		t.Errorf("TestTreeExport(dot): No error expected, got %v", err)
+	}
+	sb.Reset()
+	err = tree.Export(&sb, FormatDOT, RenderOptions{})
+	part := "[label=\"say \\\"hi\\\"\\\\ä\u200b.txt\", shape=note]"
+	if err != nil || !strings.Contains(sb.String(), part) {
+		t.Errorf("TestTreeExport(dot): Expected %v to be contained in %v (%v)", part, sb.String(), err)
+	}
+}
+
+// syntheticFilesystem returns a simulated filesystem with n entries
+// below /data, which are distributed over three directory levels.
+func syntheticFilesystem(n int) Filesystem {
+	fs := Filesystem{}
+	fs.Init()
+	fs.MkDir("/data")
+	count := 1
+	for i := 0; count < n; i++ {
+		dir := fmt.Sprintf("/data/d%03v/d%03v", i/100, i%100)
+		fs.MkDir(dir)
+		count += 2
+		for j := 0; j < 100 && count < n; j++ {
+			fs.CreateFile(fmt.Sprintf("%v/file%03v.txt", dir, j))
+			count++
+		}
+	}
+	return fs
+}
+
+func BenchmarkTreeCreate(b *testing.B) {
+	for _, n := range []int{1000, 100000, 1000000} {
+		fs := syntheticFilesystem(n)
+		b.Run(fmt.Sprintf("%v", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tree := Tree{}
+				err := tree.Create(fs["/data"], fs)
+				if err != nil {
+					b.Fatalf("BenchmarkTreeCreate: No error expected, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTreeCreateEmpty(t *testing.T) {
+	fs := Filesystem{}
+	fs.Init()
+	fs.MkDir("/tmp")
+
+	tree := Tree{}
+	err := tree.Create(fs["/tmp"], fs)
+	if err != nil {
+		t.Errorf("TestTreeCreateEmpty: No error expected, got %v", err)
+	}
+	if tree.Count() != 1 {
+		t.Errorf("TestTreeCreateEmpty: Expected 1 element, got %v", tree.Count())
+	}
+}
+
+func TestTreeTraversal(t *testing.T) {
+	fs := Filesystem{}
+	fs.Init()
+	fs.MkDir("/tmp/a/b")
+	fs.MkDir("/tmp/c")
+	fs.CreateFile("/tmp/a/b/deep.txt")
+	fs.CreateFile("/tmp/a/hello.txt")
+	fs.CreateFile("/tmp/c/world.txt")
+
+	tree := Tree{}
+	err := tree.Create(fs["/tmp"], fs)
+	if err != nil {
+		t.Errorf("TestTreeTraversal: No error expected, got %v", err)
+	}
+
+	if tree.Node().FullPath() != "/tmp" {
+		t.Errorf("Node: Expected /tmp, got %v", tree.Node().FullPath())
+	}
+	children := tree.Children()
+	if len(children) != 2 || children[0].Node().Name() != "a" || children[1].Node().Name() != "c" {
+		t.Errorf("Children: Expected a and c, got %v", children)
+	}
+	if tree.Depth() != 3 {
+		t.Errorf("Depth: Expected 3, got %v", tree.Depth())
+	}
+	if tree.FileCount() != 3 {
+		t.Errorf("FileCount: Expected 3, got %v", tree.FileCount())
+	}
+	if _, known := tree.TotalSize(); known {
+		t.Errorf("TotalSize: Expected unknown size for simulated files")
+	}
+
+	for _, p := range []string{"/tmp/a/b/deep.txt", "a/b/deep.txt"} {
+		found := tree.Find(p)
+		if found == nil || found.Node().FullPath() != "/tmp/a/b/deep.txt" {
+			t.Errorf("Find(%v): Expected /tmp/a/b/deep.txt, got %v", p, found)
+		}
+	}
+	for _, p := range []string{"/tmp/a/missing", "/var/a", "/tmpa/b", "c/world.txt/x"} {
+		if found := tree.Find(p); found != nil {
+			t.Errorf("Find(%v): Expected nil, got %v", p, found.Node().FullPath())
+		}
+	}
+	if tree.Find("/tmp") != &tree || tree.Find(".") != &tree {
+		t.Errorf("Find: Expected the root element")
+	}
+
+	visited := []string{}
+	err = tree.Walk(func(st *Tree, depth int) error {
+		visited = append(visited, fmt.Sprintf("%v:%v", depth, st.Node().Name()))
+		if st.Node().Name() == "b" {
+			return SkipSubtree
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Walk: No error expected, got %v", err)
+	}
+	expected := "[0:tmp 1:a 2:b 2:hello.txt 1:c 2:world.txt]"
+	if fmt.Sprintf("%v", visited) != expected {
+		t.Errorf("Walk: Expected %v, got %v", expected, visited)
+	}
+
+	errStop := errors.New("stop")
+	err = tree.Walk(func(st *Tree, depth int) error {
+		return errStop
+	})
+	if err != errStop {
+		t.Errorf("Walk: Expected %v, got %v", errStop, err)
+	}
+}
+
+func TestParseTree(t *testing.T) {
+	treeString := `/home/goggle/example
+├── c_progs
+│   └── prog01
+│       ├── hello
+│       └── hello.c
+├── data
+│   ├── dat001
+│   │   └── data_apples.txt
+│   └── empty/
+└── hello
+`
+	fs, err := ParseTree(strings.NewReader(treeString))
+	if err != nil {
+		t.Fatalf("ParseTree: No error expected, got %v", err)
+	}
+	expectedFs := Filesystem{}
+	expectedFs.Init()
+	expectedFs.MkDir("/home/goggle/example/c_progs/prog01")
+	expectedFs.MkDir("/home/goggle/example/data/dat001")
+	expectedFs.MkDir("/home/goggle/example/data/empty")
+	expectedFs.CreateFile("/home/goggle/example/c_progs/prog01/hello")
+	expectedFs.CreateFile("/home/goggle/example/c_progs/prog01/hello.c")
+	expectedFs.CreateFile("/home/goggle/example/data/dat001/data_apples.txt")
+	expectedFs.CreateFile("/home/goggle/example/hello")
+	if !fs.Equal(expectedFs) {
+		t.Errorf("ParseTree: Expected %v, got %v", expectedFs, fs)
+	}
+
+	// Round trip through Tree.Create and Tree.String:
+	tree := Tree{}
+	err = tree.Create(fs["/home/goggle/example"], fs)
+	if err != nil {
+		t.Errorf("ParseTree: No error expected, got %v", err)
+	}
+	expected := strings.Replace(treeString, "empty/", "empty", 1)
+	if tree.String() != expected {
+		t.Errorf("ParseTree: Expected %v, got %v", expected, tree.String())
+	}
+
+	// Output of the tree command, with non-breaking spaces and ASCII:
+	for _, gnuTree := range []string{
+		".\n├── a\n│\u00a0\u00a0 └── b.txt\n└── c\n\n1 directory, 2 files\n",
+		".\n|-- a\n|   `-- b.txt\n`-- c\n\n1 directory, 2 files\n",
+	} {
+		fs, err = ParseTree(strings.NewReader(gnuTree))
+		if err != nil {
+			t.Errorf("ParseTree: No error expected, got %v", err)
+		}
+		if !fs.IsDirectory("/a") || !fs.IsRegularFile("/a/b.txt") || !fs.IsRegularFile("/c") || len(fs) != 4 {
+			t.Errorf("ParseTree: Unexpected filesystem %v", fs)
+		}
+	}
+
+	// An empty directory in the output of tree without -F cannot be
+	// told apart from a file, but the summary line tells about it:
+	_, err = ParseTree(strings.NewReader(".\n├── a\n│   └── b.txt\n└── empty\n\n2 directories, 1 file\n"))
+	if err == nil {
+		t.Errorf("ParseTree: Expected an error for an ambiguous empty directory")
+	}
+
+	for _, invalid := range []string{"", "/tmp\nhello\n", "/tmp\n│   └── deep\n"} {
+		_, err = ParseTree(strings.NewReader(invalid))
+		if err == nil {
+			t.Errorf("ParseTree(%q): Error expected, got nil", invalid)
+		}
+	}
+}
+
+func TestParseTreeClassified(t *testing.T) {
+	// Output of tree -F with an empty directory, an executable and
+	// a symbolic link:
+	classified := `.
+├── bin/
+│   ├── latest@
+│   └── run.sh*
+├── empty/
+├── notes.txt
+└── src/
+    └── main.c
+
+3 directories, 4 files
+`
+	fs, err := ParseTree(strings.NewReader(classified))
+	if err != nil {
+		t.Fatalf("ParseTree: No error expected, got %v", err)
+	}
+	expected := Filesystem{}
+	expected.Init()
+	for _, d := range []string{"/bin", "/empty", "/src"} {
+		expected.MkDir(d)
+	}
+	for _, f := range []string{"/bin/run.sh", "/bin/latest", "/notes.txt", "/src/main.c"} {
+		expected.CreateFile(f)
+	}
+	if !fs.Equal(expected) {
+		t.Errorf("ParseTree: Expected %v, got %v", expected, fs)
+	}
+
+	// Round trip through Tree.Render with the markers of tree -F. The
+	// executable keeps its marker, if its mode is known:
+	run := fs["/bin/run.sh"]
+	run.HasMetadata = true
+	run.FileMode = 0755
+	fs["/bin/run.sh"] = run
+	tree := Tree{}
+	err = tree.Create(fs["/"], fs)
+	if err != nil {
+		t.Fatalf("Create: No error expected, got %v", err)
+	}
+	rendered := tree.Render(RenderOptions{Classify: true})
+	expectedRendered := strings.Replace(strings.Replace(classified, "latest@", "latest", 1), ".\n", "/\n", 1)
+	expectedRendered = expectedRendered[:strings.Index(expectedRendered, "\n\n")+1]
+	if rendered != expectedRendered {
+		t.Errorf("Render: Expected\n%v\ngot\n%v", expectedRendered, rendered)
+	}
+	again, err := ParseTree(strings.NewReader(rendered))
+	if err != nil || !again.Equal(fs) {
+		t.Errorf("ParseTree: Expected the rendered tree to round trip, got %v (%v)", again, err)
+	}
+}
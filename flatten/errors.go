@@ -0,0 +1,74 @@
+package flatten
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotDirectory is returned if the source or the destination
+// of a flattening process is not a directory.
+var ErrNotDirectory = errors.New("not a directory")
+
+// CollisionError is returned if a file cannot be copied or moved,
+// because its destination already exists.
+type CollisionError struct {
+	Source      string
+	Destination string
+}
+
+func (e *CollisionError) Error() string {
+	return "cannot flatten " + e.Source + ": " + e.Destination + " already exists"
+}
+
+// FileOpError records the failure of a single copy or move operation
+// together with the error which caused it.
+type FileOpError struct {
+	Op  Operation
+	Err error
+}
+
+func (e *FileOpError) Error() string {
+	action := "move"
+	if e.Op.Copy {
+		action = "copy"
+	}
+	return "could not " + action + " " + e.Op.Source + " to " + e.Op.Destination + ": " + e.Err.Error()
+}
+
+// Unwrap returns the error which caused the operation to fail.
+func (e *FileOpError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError collects the errors of a flattening process, which
+// has been continued after failures (see Options.KeepGoing).
+type MultiError struct {
+	Errors []error
+}
+
+func (e *MultiError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	lines := make([]string, 0, len(e.Errors)+1)
+	lines = append(lines, fmt.Sprintf("%v errors occurred:", len(e.Errors)))
+	for _, err := range e.Errors {
+		lines = append(lines, "  "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap returns the collected errors, so that they can be
+// inspected with errors.Is and errors.As.
+func (e *MultiError) Unwrap() []error {
+	return e.Errors
+}
+
+// errOrNil returns e if it contains any errors, otherwise nil.
+func (e *MultiError) errOrNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
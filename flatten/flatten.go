@@ -1,34 +1,132 @@
 package flatten
 
 import (
-	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/goggle/flatten/osabstraction"
 )
 
-// verbose is the verbosity flag
+// verbose is the verbosity flag used by the package level
+// Flatten function.
 var verbose = false
 
-// SetVerbose sets the verbosity flag
+// SetVerbose sets the verbosity flag of the package level
+// Flatten function.
+//
+// Deprecated: Set Options.Verbose on a Flattener instead.
 func SetVerbose() {
 	verbose = true
 }
 
-func countFileNames(files []osabstraction.FileInfo) map[string]int {
+// Options contains the settings of a flattening process.
+type Options struct {
+	// CopyOnly indicates that files are copied instead of moved,
+	// so nothing gets removed from the source directory.
+	CopyOnly bool
+	// IncludeBaseFiles indicates that the files which are directly
+	// located in the source directory are flattened as well.
+	IncludeBaseFiles bool
+	// Verbose indicates that every action gets reported to the
+	// output writer of the Flattener.
+	Verbose bool
+	// KeepGoing indicates that the process continues with the
+	// remaining files if a file cannot be copied or moved. All
+	// the failures are returned together as a *MultiError.
+	KeepGoing bool
+	// CaseInsensitive indicates that the destination does not
+	// distinguish the names, which only differ by their case, like
+	// vfat, exFAT, SMB shares or casefolded directories of ext4. Such
+	// names collide then and get numbered.
+	CaseInsensitive bool
+	// Normalization is the Unicode normalization form, to which the
+	// names of the flattened files are converted, since names which
+	// only differ by their normalization collide on some destinations.
+	// It is empty, if the names are kept as they are.
+	Normalization Normalization
+	// Profile is the kind of filesystem of the destination. The names
+	// of the flattened files are sanitized according to its rules, and
+	// the names, which become equal, collide and get numbered. It is
+	// empty, if the names are not restricted.
+	Profile Profile
+	// MaxNameBytes is the maximum length of the names of the flattened
+	// files in bytes. Longer names are shortened, so that their
+	// extension and their number are kept. It is DefaultMaxNameBytes,
+	// if zero, and the names are not limited, if it is negative.
+	MaxNameBytes int
+}
+
+// DefaultMaxNameBytes is the maximum length of a name in bytes on most
+// filesystems (NAME_MAX).
+const DefaultMaxNameBytes = 255
+
+// Flattener performs the flattening of a directory structure on
+// the filesystem it has been created with. Different Flattener
+// values are independent of each other and can be used concurrently.
+type Flattener struct {
+	options   Options
+	osw       osabstraction.OSWrapper
+	out       io.Writer
+	observers observers
+}
+
+// New creates a Flattener, which operates on osw using the
+// options opts. Messages are written to os.Stdout.
+func New(osw osabstraction.OSWrapper, opts Options) *Flattener {
+	if opts.folds() {
+		osw = newFoldingOS(osw, opts)
+	}
+	return &Flattener{options: opts, osw: osw, out: os.Stdout}
+}
+
+// SetOutput sets the writer to which the messages of the
+// Flattener f get written.
+func (f *Flattener) SetOutput(w io.Writer) {
+	f.out = w
+}
+
+// AddObserver registers o, so that it gets notified about
+// the progress of the flattening processes performed by f.
+func (f *Flattener) AddObserver(o Observer) {
+	f.observers = append(f.observers, o)
+}
+
+// Options returns the options of the Flattener f.
+func (f *Flattener) Options() Options {
+	return f.options
+}
+
+// notifier returns the observers which get notified during a
+// flattening process, including the logger for the verbose mode.
+func (f *Flattener) notifier() observers {
+	obs := observers{}
+	if f.options.Verbose && f.out != nil {
+		obs = append(obs, LogObserver{W: f.out})
+	}
+	return append(obs, f.observers...)
+}
+
+// countFileNames counts the files per key of their names (see
+// Options.nameKey).
+func countFileNames(files []osabstraction.FileInfo, key func(name string) string) map[string]int {
 	countMap := map[string]int{}
 	for _, file := range files {
-		countMap[file.Name()]++
+		countMap[key(file.Name())]++
 	}
 	return countMap
 }
 
-func evaluateAppendixLength(destination string, filename string, occurences int, osw osabstraction.OSWrapper) int {
+// evaluateAppendixLength returns the length of the numbers, which are
+// appended to the occurences of filename, so that none of the names
+// returned by generate exists in destination yet.
+func evaluateAppendixLength(destination string, filename string, occurences int, osw osabstraction.OSWrapper, generate func(string, int, int) string) int {
 	if occurences == 1 {
-		if !osw.Exists(filepath.Join(destination, filename)) {
+		if !osw.Exists(filepath.Join(destination, generate(filename, 1, 0))) {
 			return 0
 		}
 	}
@@ -38,7 +136,7 @@ func evaluateAppendixLength(destination string, filename string, occurences int,
 	for {
 		works := true
 		for i := 1; i <= occurences; i++ {
-			fname := generateFilename(filename, i, numberDigits)
+			fname := generate(filename, i, numberDigits)
 			fullpath := filepath.Join(destination, fname)
 			if !osw.Exists(fullpath) {
 				continue
@@ -71,62 +169,158 @@ func baseName(filename string) string {
 	return filename[:j]
 }
 
-// Flatten performs the "flattening" of the directory structure.
+// Flatten performs the "flattening" of the directory structure. As
+// before the introduction of Options.MaxNameBytes, the names are not
+// shortened.
 func Flatten(source, destination osabstraction.FileInfo, osw osabstraction.OSWrapper, copyOnly bool, includeBaseFiles bool) error {
+	f := New(osw, Options{CopyOnly: copyOnly, IncludeBaseFiles: includeBaseFiles, Verbose: verbose, MaxNameBytes: -1})
+	return f.Flatten(source, destination)
+}
+
+// Flatten performs the "flattening" of the directory structure
+// from source to destination.
+func (f *Flattener) Flatten(source, destination osabstraction.FileInfo) (err error) {
+	obs := f.notifier()
+	defer func() {
+		obs.OnFinish(err)
+	}()
+
+	ops, err := f.Plan(source, destination)
+	if err != nil {
+		return err
+	}
+	obs.OnPlanned(ops)
+
+	failures := &MultiError{}
+	for _, op := range ops {
+		if op.Source == op.Destination {
+			obs.OnSkip(op, "source and destination are identical")
+			continue
+		}
+		obs.OnFileStart(op)
+		opErr := f.perform(op)
+		if opErr != nil {
+			obs.OnError(op, opErr)
+			if !f.options.KeepGoing {
+				return &FileOpError{Op: op, Err: opErr}
+			}
+			failures.Errors = append(failures.Errors, &FileOpError{Op: op, Err: opErr})
+			continue
+		}
+		obs.OnFileDone(op)
+	}
+
+	if !f.options.CopyOnly {
+		rmErr := f.removeSubDirectories(source.FullPath(), obs)
+		if rmErr != nil {
+			if !f.options.KeepGoing {
+				return rmErr
+			}
+			failures.Errors = append(failures.Errors, rmErr)
+		}
+	}
+	return failures.errOrNil()
+}
+
+// perform copies or moves a single file. Existing files are
+// never overwritten.
+func (f *Flattener) perform(op Operation) error {
+	if f.osw.Exists(op.Destination) {
+		return &CollisionError{Source: op.Source, Destination: op.Destination}
+	}
+	if op.Copy {
+		return f.osw.Copy(op.Source, op.Destination)
+	}
+	return f.osw.Move(op.Source, op.Destination)
+}
+
+// Plan evaluates the operations, which are needed to flatten
+// the directory structure from source to destination, without
+// performing any of them.
+func (f *Flattener) Plan(source, destination osabstraction.FileInfo) ([]Operation, error) {
+	osw := f.osw
 	if !osw.IsDirectory(source.FullPath()) {
-		return errors.New(source.FullPath() + " is not a directory")
+		return nil, fmt.Errorf("%v is %w", source.FullPath(), ErrNotDirectory)
 	}
 	if !osw.IsDirectory(destination.FullPath()) {
-		return errors.New(destination.FullPath() + " is not a directory")
+		return nil, fmt.Errorf("%v is %w", destination.FullPath(), ErrNotDirectory)
 	}
 
-	files, err := osw.GetFiles(source.FullPath(), includeBaseFiles)
+	files, err := osw.GetFiles(source.FullPath(), f.options.IncludeBaseFiles)
 	if err != nil {
-		return errors.New("could not retrieve files in " + source.FullPath())
+		return nil, fmt.Errorf("could not retrieve files in %v: %w", source.FullPath(), err)
 	}
-	countMap := countFileNames(files)
+	// Names, which are equal on the destination after they have been
+	// sanitized, share the same key and collide with each other:
+	generate := func(name string, index int, length int) string {
+		newName, _ := f.options.filename(name, index, length)
+		return newName
+	}
+	key := func(name string) string {
+		return f.options.nameKey(generate(name, 1, 0))
+	}
+	countMap := countFileNames(files, key)
 	lenAppendixMap := map[string]int{}
 	currentIndexMap := map[string]int{}
-	for k, v := range countMap {
-		l := evaluateAppendixLength(destination.FullPath(), k, v, osw)
-		lenAppendixMap[k] = l
+	for _, srcFile := range files {
+		k := key(srcFile.Name())
+		if _, evaluated := lenAppendixMap[k]; evaluated {
+			continue
+		}
+		lenAppendixMap[k] = evaluateAppendixLength(destination.FullPath(), srcFile.Name(), countMap[k], osw, generate)
 		currentIndexMap[k] = 1
 	}
 
+	// The names of different keys might still be equal, once they have
+	// been numbered and shortened, so that the index is increased until
+	// the name has neither been planned nor exists yet:
+	planned := map[string]bool{}
+	ops := make([]Operation, 0, len(files))
 	for _, srcFile := range files {
-		name := srcFile.Name()
-		lenAppendix, _ := lenAppendixMap[name]
-		currIndex, _ := currentIndexMap[name]
-		currentIndexMap[name]++
-		newName := generateFilename(name, currIndex, lenAppendix)
+		k := key(srcFile.Name())
+		currIndex := currentIndexMap[k]
+		newName, truncated := f.options.filename(srcFile.Name(), currIndex, lenAppendixMap[k])
 		newNameFullpath := filepath.Join(destination.FullPath(), newName)
-		if copyOnly {
-			if verbose {
-				fmt.Println("Copying " + srcFile.FullPath() + " to " + newNameFullpath)
-			}
-			err := osw.Copy(srcFile.FullPath(), newNameFullpath)
-			if err != nil {
-				return err
-			}
-		} else {
-			if verbose {
-				fmt.Println("Moving " + srcFile.FullPath() + " to " + newNameFullpath)
-			}
-			err := osw.Move(srcFile.FullPath(), newNameFullpath)
-			if err != nil {
-				return err
+		for planned[f.options.nameKey(newName)] || osw.Exists(newNameFullpath) {
+			if lenAppendixMap[k] == 0 {
+				lenAppendixMap[k] = 1
+			} else {
+				currIndex++
 			}
+			newName, truncated = f.options.filename(srcFile.Name(), currIndex, lenAppendixMap[k])
+			newNameFullpath = filepath.Join(destination.FullPath(), newName)
 		}
+		currentIndexMap[k] = currIndex + 1
+		planned[f.options.nameKey(newName)] = true
+		ops = append(ops, Operation{
+			Source:      srcFile.FullPath(),
+			Destination: newNameFullpath,
+			Copy:        f.options.CopyOnly,
+			Truncated:   truncated,
+		})
 	}
+	return ops, nil
+}
 
-	if !copyOnly {
-		if verbose {
-			fmt.Println("Removing subdirectories from " + source.FullPath())
-		}
-		err := osw.RemoveSubDirectories(source.FullPath())
-		if err != nil {
-			return err
+// removeSubDirectories removes all the subdirectories of p and
+// notifies obs about every removed directory.
+func (f *Flattener) removeSubDirectories(p string, obs observers) error {
+	dirs, err := f.osw.GetDirectories(p)
+	if err != nil {
+		return err
+	}
+	err = f.osw.RemoveSubDirectories(p)
+	paths := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		paths = append(paths, d.FullPath())
+	}
+	// Report the deepest directories first, since this is the
+	// order in which they have to be removed:
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	for _, d := range paths {
+		if !f.osw.Exists(d) {
+			obs.OnDirRemoved(d)
 		}
 	}
-	return nil
+	return err
 }
@@ -1,10 +1,18 @@
 package flatten
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"unicode/utf8"
 
 	"github.com/goggle/flatten/filesystem"
+	"github.com/goggle/flatten/osabstraction"
 )
 
 func TestGenerateFilename(t *testing.T) {
@@ -44,7 +52,7 @@ func TestCountFileNames(t *testing.T) {
 	fs.CreateFile("/tmp/c/hello")
 	fs.CreateFile("/tmp/c/nf.txt")
 	files, _ := fs.GetFiles("/tmp", false)
-	m := countFileNames(files)
+	m := countFileNames(files, Options{}.nameKey)
 
 	expectedLength := 2
 	if len(m) != expectedLength {
@@ -71,7 +79,7 @@ func TestEvaluateAppendixLength(t *testing.T) {
 	fs.CreateFile("/tmp/a/hello")
 
 	expected := 0
-	result := evaluateAppendixLength("/tmp", "hello", 1, fs)
+	result := evaluateAppendixLength("/tmp", "hello", 1, fs, generateFilename)
 	if expected != result {
 		t.Errorf("evaluateAppendixLength: expected %v, got %v", expected, result)
 	}
@@ -79,7 +87,7 @@ func TestEvaluateAppendixLength(t *testing.T) {
 	fs.MkDir("/tmp/b")
 	fs.CreateFile("/tmp/b/hello")
 	expected = 1
-	result = evaluateAppendixLength("/tmp", "hello", 2, fs)
+	result = evaluateAppendixLength("/tmp", "hello", 2, fs, generateFilename)
 	if expected != result {
 		t.Errorf("evaluateAppendixLength: expected %v, got %v", expected, result)
 	}
@@ -90,7 +98,7 @@ func TestEvaluateAppendixLength(t *testing.T) {
 		fs.CreateFile("/tmp/c" + stri + "/hello")
 	}
 	expected = 2
-	result = evaluateAppendixLength("/tmp", "hello", 17, fs)
+	result = evaluateAppendixLength("/tmp", "hello", 17, fs, generateFilename)
 	if expected != result {
 		t.Errorf("evaluateAppendixLength: expected %v, got %v", expected, result)
 	}
@@ -101,21 +109,21 @@ func TestEvaluateAppendixLength(t *testing.T) {
 		fs.CreateFile("/tmp/d" + stri + "/hello")
 	}
 	expected = 3
-	result = evaluateAppendixLength("/tmp", "hello", 100, fs)
+	result = evaluateAppendixLength("/tmp", "hello", 100, fs, generateFilename)
 	if expected != result {
 		t.Errorf("evaluateAppendixLength: expected %v, got %v", expected, result)
 	}
 
 	fs.CreateFile("/tmp/hello_001")
 	expected = 4
-	result = evaluateAppendixLength("/tmp", "hello", 100, fs)
+	result = evaluateAppendixLength("/tmp", "hello", 100, fs, generateFilename)
 	if expected != result {
 		t.Errorf("evaluateAppendixLength: expected %v, got %v", expected, result)
 	}
 
 	fs.CreateFile("/tmp/hello_0100")
 	expected = 5
-	result = evaluateAppendixLength("/tmp", "hello", 100, fs)
+	result = evaluateAppendixLength("/tmp", "hello", 100, fs, generateFilename)
 	if expected != result {
 		t.Errorf("evaluateAppendixLength: expected %v, got %v", expected, result)
 	}
@@ -279,3 +287,474 @@ func TestFlattenFour(t *testing.T) {
 	}
 
 }
+
+func TestFlattenerVerbose(t *testing.T) {
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	fs.MkDir("/tmp/a")
+	fs.CreateFile("/tmp/a/hello.txt")
+
+	var buf bytes.Buffer
+	f := New(fs, Options{CopyOnly: true, Verbose: true})
+	f.SetOutput(&buf)
+	err := f.Flatten(fs["/tmp"], fs["/tmp"])
+	if err != nil {
+		t.Errorf("Flatten: no error expected, got %v", err)
+	}
+	expected := "Copying /tmp/a/hello.txt to /tmp/hello.txt\n"
+	if buf.String() != expected {
+		t.Errorf("Flatten: expected output %q, got %q", expected, buf.String())
+	}
+
+	// Without the verbose option, nothing gets written:
+	buf.Reset()
+	fs.MkDir("/tmp/b")
+	fs.CreateFile("/tmp/b/world.txt")
+	f = New(fs, Options{})
+	f.SetOutput(&buf)
+	err = f.Flatten(fs["/tmp"], fs["/tmp"])
+	if err != nil {
+		t.Errorf("Flatten: no error expected, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Flatten: expected no output, got %q", buf.String())
+	}
+}
+
+type recordingObserver struct {
+	BaseObserver
+	planned  []Operation
+	started  []Operation
+	done     []Operation
+	removed  []string
+	finished bool
+	err      error
+}
+
+func (ro *recordingObserver) OnPlanned(ops []Operation) {
+	ro.planned = ops
+}
+
+func (ro *recordingObserver) OnFileStart(op Operation) {
+	ro.started = append(ro.started, op)
+}
+
+func (ro *recordingObserver) OnFileDone(op Operation) {
+	ro.done = append(ro.done, op)
+}
+
+func (ro *recordingObserver) OnDirRemoved(dir string) {
+	ro.removed = append(ro.removed, dir)
+}
+
+func (ro *recordingObserver) OnFinish(err error) {
+	ro.finished = true
+	ro.err = err
+}
+
+func TestFlattenerObserver(t *testing.T) {
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	fs.MkDir("/tmp/a/aa")
+	fs.MkDir("/tmp/b")
+	fs.CreateFile("/tmp/a/aa/hello.txt")
+	fs.CreateFile("/tmp/b/hello.txt")
+
+	ro := &recordingObserver{}
+	f := New(fs, Options{})
+	f.AddObserver(ro)
+	err := f.Flatten(fs["/tmp"], fs["/tmp"])
+	if err != nil {
+		t.Errorf("Flatten: no error expected, got %v", err)
+	}
+	if len(ro.planned) != 2 || len(ro.started) != 2 || len(ro.done) != 2 {
+		t.Errorf("Observer: expected 2 planned, started and done operations, got %v, %v and %v", len(ro.planned), len(ro.started), len(ro.done))
+	}
+	expectedRemoved := []string{"/tmp/b", "/tmp/a/aa", "/tmp/a"}
+	if fmt.Sprintf("%v", ro.removed) != fmt.Sprintf("%v", expectedRemoved) {
+		t.Errorf("Observer: expected removed directories %v, got %v", expectedRemoved, ro.removed)
+	}
+	if !ro.finished || ro.err != nil {
+		t.Errorf("Observer: expected OnFinish to be called without error, got %v, %v", ro.finished, ro.err)
+	}
+
+	// OnFinish also gets called, if the process fails:
+	ro = &recordingObserver{}
+	f.AddObserver(ro)
+	err = f.Flatten(fs["/tmp/hello_1.txt"], fs["/tmp"])
+	if err == nil {
+		t.Errorf("Flatten: error expected, got nil")
+	}
+	if !ro.finished || ro.err != err {
+		t.Errorf("Observer: expected OnFinish to be called with %v, got %v, %v", err, ro.finished, ro.err)
+	}
+}
+
+// failingFilesystem is a simulated filesystem on which moving
+// the files in failDir fails.
+type failingFilesystem struct {
+	filesystem.Filesystem
+	failDir string
+}
+
+var errMoveFailed = errors.New("move failed")
+
+func (ffs failingFilesystem) Move(src, dst string) error {
+	if strings.HasPrefix(src, ffs.failDir+"/") {
+		return errMoveFailed
+	}
+	return ffs.Filesystem.Move(src, dst)
+}
+
+func TestFlattenErrors(t *testing.T) {
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	fs.MkDir("/tmp/a")
+	fs.MkDir("/tmp/b")
+	fs.CreateFile("/tmp/a/hello.txt")
+	fs.CreateFile("/tmp/a/world.txt")
+	fs.CreateFile("/tmp/b/song.flac")
+	fs.CreateFile("/tmp/c")
+
+	err := New(fs, Options{}).Flatten(fs["/tmp/c"], fs["/tmp"])
+	if !errors.Is(err, ErrNotDirectory) {
+		t.Errorf("Flatten: expected ErrNotDirectory, got %v", err)
+	}
+
+	// Without KeepGoing, the first failure aborts the process:
+	ffs := failingFilesystem{Filesystem: fs, failDir: "/tmp/a"}
+	err = New(ffs, Options{}).Flatten(fs["/tmp"], fs["/tmp"])
+	var opErr *FileOpError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("Flatten: expected *FileOpError, got %v", err)
+	}
+	if !errors.Is(err, errMoveFailed) || opErr.Op.Source != "/tmp/a/hello.txt" && opErr.Op.Source != "/tmp/a/world.txt" {
+		t.Errorf("Flatten: expected failing move from /tmp/a, got %v", err)
+	}
+
+	// With KeepGoing, all the other files get moved:
+	fs = filesystem.Filesystem{}
+	fs.Init()
+	fs.MkDir("/tmp/a")
+	fs.MkDir("/tmp/b")
+	fs.CreateFile("/tmp/a/hello.txt")
+	fs.CreateFile("/tmp/a/world.txt")
+	fs.CreateFile("/tmp/b/song.flac")
+	ffs = failingFilesystem{Filesystem: fs, failDir: "/tmp/a"}
+	err = New(ffs, Options{KeepGoing: true}).Flatten(fs["/tmp"], fs["/tmp"])
+	var multiErr *MultiError
+	if !errors.As(err, &multiErr) {
+		t.Fatalf("Flatten: expected *MultiError, got %v", err)
+	}
+	// Two failed moves and the failed removal of /tmp/a:
+	if len(multiErr.Errors) != 3 {
+		t.Errorf("Flatten: expected 3 errors, got %v", multiErr)
+	}
+	if !errors.Is(err, errMoveFailed) {
+		t.Errorf("Flatten: expected errMoveFailed in %v", err)
+	}
+	if !fs.IsRegularFile("/tmp/song.flac") || fs.Exists("/tmp/b/song.flac") {
+		t.Errorf("Flatten: expected /tmp/b/song.flac to be moved, got %v", fs)
+	}
+
+	// Existing files never get overwritten:
+	fs = filesystem.Filesystem{}
+	fs.Init()
+	fs.MkDir("/tmp/a")
+	fs.CreateFile("/tmp/a/hello.txt")
+	f := New(fs, Options{})
+	ops, _ := f.Plan(fs["/tmp"], fs["/tmp"])
+	fs.CreateFile("/tmp/hello.txt")
+	err = f.perform(ops[0])
+	var collisionErr *CollisionError
+	if !errors.As(err, &collisionErr) || collisionErr.Destination != "/tmp/hello.txt" {
+		t.Errorf("perform: expected *CollisionError for /tmp/hello.txt, got %v", err)
+	}
+}
+
+func TestFlattenFSOverlay(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"a/data.txt":   {Data: []byte("a")},
+		"b/data.txt":   {Data: []byte("b")},
+		"b/c/note.txt": {Data: []byte("c")},
+	}
+	o := filesystem.NewFSOverlay(mapFS, "/mnt")
+	root, _ := o.Lookup("/mnt")
+	err := New(o, Options{}).Flatten(root, root)
+	if err != nil {
+		t.Errorf("Flatten: no error expected, got %v", err)
+	}
+	for _, p := range []string{"/mnt/data_1.txt", "/mnt/data_2.txt", "/mnt/note.txt"} {
+		if !o.IsRegularFile(p) {
+			t.Errorf("Flatten: expected %v to exist", p)
+		}
+	}
+	dirs, err := o.GetDirectories("/mnt")
+	if err != nil || len(dirs) != 0 {
+		t.Errorf("Flatten: expected all the directories to be removed, got %v (%v)", dirs, err)
+	}
+}
+
+func TestFlattenFolding(t *testing.T) {
+	nfc, nfd := "café.txt", "café.txt"
+	newFS := func() filesystem.Filesystem {
+		fs := filesystem.Filesystem{}
+		fs.Init()
+		for _, p := range []string{"/src/a/Report.PDF", "/src/b/report.pdf", "/src/c/" + nfc, "/src/d/" + nfd, "/src/e/readme.md", "/dst/README.md"} {
+			fs.CreateFile(p)
+		}
+		return fs
+	}
+	tests := []struct {
+		opts     Options
+		expected []string
+	}{
+		{Options{}, []string{"Report.PDF", "report.pdf", nfc, nfd, "readme.md"}},
+		{Options{CaseInsensitive: true}, []string{"Report_1.PDF", "report_2.pdf", nfc, nfd, "readme_1.md"}},
+		{Options{Normalization: NFC}, []string{"Report.PDF", "report.pdf", "café_1.txt", "café_2.txt", "readme.md"}},
+		{Options{CaseInsensitive: true, Normalization: NFD}, []string{"Report_1.PDF", "report_2.pdf", "café_1.txt", "café_2.txt", "readme_1.md"}},
+	}
+	for _, test := range tests {
+		fs := newFS()
+		ops, err := New(fs, test.opts).Plan(filesystem.DummyFile{Path: "/src", IsDirectory: true}, filesystem.DummyFile{Path: "/dst", IsDirectory: true})
+		if err != nil {
+			t.Errorf("Plan(%+v): no error expected, got %v", test.opts, err)
+			continue
+		}
+		names := []string{}
+		for _, op := range ops {
+			names = append(names, filepath.Base(op.Destination))
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("Plan(%+v): expected %q, got %q", test.opts, test.expected, names)
+		}
+	}
+
+	// The files, which arrive during the process, are found under their
+	// folded names as well:
+	fs := newFS()
+	f := New(fs, Options{CaseInsensitive: true})
+	err := fs.CreateFile("/dst/x/REPORT.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.osw.Exists("/dst/x/report.PDF") || f.osw.Exists("/dst/x/report.txt") {
+		t.Errorf("Exists: expected only the folded name to be found")
+	}
+	err = f.osw.Move("/src/a/Report.PDF", "/dst/Report_9.PDF")
+	if err != nil || !f.osw.Exists("/dst/report_9.pdf") || f.osw.Exists("/src/a/report.pdf") {
+		t.Errorf("Move: expected the folded index to follow the file (%v)", err)
+	}
+}
+
+// countingOS counts the listings of a filesystem.
+type countingOS struct {
+	osabstraction.OSWrapper
+	walks  int
+	listed []string
+}
+
+func (c *countingOS) GetFiles(dir string, includeBaseFiles bool) ([]osabstraction.FileInfo, error) {
+	c.walks++
+	return c.OSWrapper.GetFiles(dir, includeBaseFiles)
+}
+
+func (c *countingOS) GetDirectories(dir string) ([]osabstraction.FileInfo, error) {
+	c.walks++
+	return c.OSWrapper.GetDirectories(dir)
+}
+
+func (c *countingOS) List(dir string) ([]osabstraction.FileInfo, error) {
+	c.listed = append(c.listed, dir)
+	return osabstraction.List(c.OSWrapper, dir)
+}
+
+func TestFoldingIndex(t *testing.T) {
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	for _, p := range []string{"/dst/Report.pdf", "/dst/sub/Deep.txt", "/dst/sub/more/Deeper.txt"} {
+		fs.CreateFile(p)
+	}
+	c := &countingOS{OSWrapper: fs}
+	fos := newFoldingOS(c, Options{CaseInsensitive: true})
+	if !fos.Exists("/dst/REPORT.PDF") || !fos.Exists("/dst/SUB") || fos.Exists("/dst/deep.txt") {
+		t.Errorf("Exists: expected only the direct children of /dst to be found")
+	}
+	if c.walks != 0 || !reflect.DeepEqual(c.listed, []string{"/dst"}) {
+		t.Errorf("Exists: expected only /dst to be listed once, got %d walks and %q listed", c.walks, c.listed)
+	}
+	expected := map[string]int{"report.pdf": 1, "sub": 1}
+	if !reflect.DeepEqual(fos.dirs["/dst"], expected) {
+		t.Errorf("index: expected %v, got %v", expected, fos.dirs["/dst"])
+	}
+}
+
+func TestTargetProfile(t *testing.T) {
+	vfat := profileRules[ProfileVFAT]
+	sanitized := map[string]string{
+		"a:b?.txt":  "a_b_.txt",
+		"CON.txt":   "CON_.txt",
+		"lpt1":      "lpt1_",
+		"console":   "console",
+		"notes. ":   "notes__",
+		"tab\there": "tab_here",
+		"":          "_",
+	}
+	for name, expected := range sanitized {
+		if result, _ := vfat.filename(name, 1, 0); result != expected {
+			t.Errorf("filename(%q): expected %q, got %q", name, expected, result)
+		}
+	}
+	// The numbered names are not reserved anymore:
+	numbered := map[string]string{
+		"CON.txt": "CON_1.txt",
+		"lpt1":    "lpt1_1",
+		"nul.":    "nul__1",
+	}
+	for name, expected := range numbered {
+		if result, _ := vfat.filename(name, 1, 1); result != expected {
+			t.Errorf("filename(%q, 1, 1): expected %q, got %q", name, expected, result)
+		}
+	}
+	if result := profileRules[ProfilePOSIX].sanitize("a:b?. "); result != "a:b?. " {
+		t.Errorf("sanitize(posix): expected the name to be kept, got %q", result)
+	}
+
+	long := strings.Repeat("ä", 200) + ".txt"
+	for _, profile := range []Profile{ProfilePOSIX, ProfileVFAT} {
+		rules := profileRules[profile]
+		name, truncated := rules.filename(long, 7, 2)
+		if truncated != (profile == ProfilePOSIX) || rules.length(name) > 255 || !strings.HasSuffix(name, "_07.txt") || !utf8.ValidString(name) {
+			t.Errorf("filename(%v): expected a valid name of at most 255 units ending with _07.txt, got %q", profile, name)
+		}
+	}
+	if name, truncated := profileRules[ProfileVFAT].filename(long, 1, 0); name != long || truncated {
+		t.Errorf("filename(vfat): expected 204 UTF-16 units to fit, got %q", name)
+	}
+
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	for _, p := range []string{"/src/a/a:b.txt", "/src/b/a?b.txt", "/src/c/README", "/src/d/readme", "/src/e/ok.txt", "/dst/AUX_.log", "/src/f/aux.log"} {
+		fs.CreateFile(p)
+	}
+	ops, err := New(fs, Options{Profile: ProfileVFAT}).Plan(filesystem.DummyFile{Path: "/src", IsDirectory: true}, filesystem.DummyFile{Path: "/dst", IsDirectory: true})
+	if err != nil {
+		t.Fatalf("Plan: no error expected, got %v", err)
+	}
+	names := []string{}
+	for _, op := range ops {
+		names = append(names, filepath.Base(op.Destination))
+	}
+	expected := []string{"a_b_1.txt", "a_b_2.txt", "README_1", "readme_2", "ok.txt", "aux_1.log"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Plan: expected %q, got %q", expected, names)
+	}
+}
+
+func TestMaxNameBytes(t *testing.T) {
+	long := strings.Repeat("ü", 150) + ".tar.gz"
+	for _, maxBytes := range []int{0, 64, 7} {
+		name, truncated := Options{MaxNameBytes: maxBytes}.filename(long, 12, 3)
+		limit := maxBytes
+		if limit == 0 {
+			limit = DefaultMaxNameBytes
+		}
+		if !truncated || len(name) > limit || !utf8.ValidString(name) {
+			t.Errorf("filename(%v): expected a valid name of at most %v bytes, got %q", maxBytes, limit, name)
+		}
+		if !strings.HasSuffix(name, "_012.gz") || maxBytes != 7 && !strings.HasSuffix(name, "ü_012.gz") {
+			t.Errorf("filename(%v): expected the number and the extension to be kept, got %q", maxBytes, name)
+		}
+	}
+	shortened := []struct {
+		maxBytes int
+		name     string
+		index    int
+		length   int
+		expected string
+	}{
+		{6, "abcdefgh", 1, 0, "abcdef"},
+		{6, "abcdefgh", 2, 1, "abcd_2"},
+		{6, "a.tar.gz", 1, 1, "a_1.gz"},
+		{6, "abc.markdown", 1, 0, "a.mark"},
+		{6, "abc.markdown", 10, 2, "_10.ma"},
+		{4, "abcdef", 123, 3, "_123"},
+		{6, "CON.txt", 1, 0, "CO.txt"},
+	}
+	for _, test := range shortened {
+		opts := Options{MaxNameBytes: test.maxBytes, Profile: ProfileNTFS}
+		if name, truncated := opts.filename(test.name, test.index, test.length); name != test.expected || !truncated {
+			t.Errorf("filename(%q, %v, %v) with %v bytes: expected %q, got %q", test.name, test.index, test.length, test.maxBytes, test.expected, name)
+		}
+	}
+	if name, truncated := (Options{MaxNameBytes: -1}).filename(long, 1, 0); name != long || truncated {
+		t.Errorf("filename(-1): expected the name to be kept, got %q", name)
+	}
+
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	a := strings.Repeat("a", 300)
+	for _, p := range []string{"/src/x/" + a + "1.txt", "/src/y/" + a + "2.txt", "/src/z/short.txt", "/dst/other.txt"} {
+		fs.CreateFile(p)
+	}
+	ops, err := New(fs, Options{}).Plan(filesystem.DummyFile{Path: "/src", IsDirectory: true}, filesystem.DummyFile{Path: "/dst", IsDirectory: true})
+	if err != nil {
+		t.Fatalf("Plan: no error expected, got %v", err)
+	}
+	expected := []string{strings.Repeat("a", 249) + "_1.txt", strings.Repeat("a", 249) + "_2.txt", "short.txt"}
+	for i, op := range ops {
+		if filepath.Base(op.Destination) != expected[i] || op.Truncated != (i < 2) {
+			t.Errorf("Plan: expected %q (truncated: %v), got %q (truncated: %v)", expected[i], i < 2, filepath.Base(op.Destination), op.Truncated)
+		}
+	}
+
+	// The package level Flatten does not shorten the names:
+	err = Flatten(fs["/src"], fs["/dst"], fs, true, false)
+	if err != nil || !fs.Exists("/dst/"+a+"1.txt") {
+		t.Errorf("Flatten: expected the long name to be kept (%v)", err)
+	}
+}
+
+func TestMaxNameBytesCollisions(t *testing.T) {
+	x, y := strings.Repeat("a", 254)+"x", strings.Repeat("a", 254)+"y"
+	tests := []struct {
+		opts     Options
+		files    []string
+		existing []string
+	}{
+		// Different names, which are equal once they are numbered:
+		{Options{}, []string{"/src/a/" + x, "/src/b/" + x, "/src/c/" + y, "/src/d/" + y}, nil},
+		{Options{}, []string{"/src/a/" + x, "/src/b/" + y}, []string{"/dst/" + x, "/dst/" + y}},
+		// Different names, which are equal once they are shortened:
+		{Options{MaxNameBytes: 6}, []string{"/src/a/abcdefgh", "/src/b/abcdefij"}, nil},
+		{Options{MaxNameBytes: 6}, []string{"/src/a/abcdefgh", "/src/b/abcd_1", "/src/c/abcd_2"}, []string{"/dst/abcdef"}},
+	}
+	for i, test := range tests {
+		fs := filesystem.Filesystem{}
+		fs.Init()
+		fs.MkDir("/dst")
+		for _, p := range append(test.files, test.existing...) {
+			fs.CreateFile(p)
+		}
+		ops, err := New(fs, test.opts).Plan(filesystem.DummyFile{Path: "/src", IsDirectory: true}, filesystem.DummyFile{Path: "/dst", IsDirectory: true})
+		if err != nil {
+			t.Errorf("Plan(%v): no error expected, got %v", i, err)
+			continue
+		}
+		limit := test.opts.MaxNameBytes
+		if limit == 0 {
+			limit = DefaultMaxNameBytes
+		}
+		destinations := map[string]bool{}
+		for _, op := range ops {
+			name := filepath.Base(op.Destination)
+			if destinations[name] || fs.Exists(op.Destination) || len(name) > limit {
+				t.Errorf("Plan(%v): expected a new name of at most %v bytes for %.20q..., got %.20q...", i, limit, op.Source, name)
+			}
+			destinations[name] = true
+		}
+		if len(destinations) != len(test.files) {
+			t.Errorf("Plan(%v): expected %v destinations, got %v", i, len(test.files), len(destinations))
+		}
+	}
+}
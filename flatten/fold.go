@@ -0,0 +1,147 @@
+package flatten
+
+import (
+	"path/filepath"
+
+	"github.com/goggle/flatten/osabstraction"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization is a Unicode normalization form of the filenames.
+type Normalization string
+
+// The supported normalization forms:
+const (
+	// NFC composes the characters, which is the form used by Linux
+	// and Windows in general.
+	NFC Normalization = "nfc"
+	// NFD decomposes the characters, which is the form used by the
+	// filesystems of macOS.
+	NFD Normalization = "nfd"
+)
+
+// caseInsensitive returns true if the names, which only differ by
+// their case, collide on the destination.
+func (opts Options) caseInsensitive() bool {
+	return opts.CaseInsensitive || profileRules[opts.Profile].caseInsensitive
+}
+
+// folds returns true if the destination does not distinguish all
+// the names, which differ byte by byte.
+func (opts Options) folds() bool {
+	return opts.caseInsensitive() || opts.Normalization != ""
+}
+
+// normalize converts the name into the normalization form of the
+// options, if there is one.
+func (opts Options) normalize(name string) string {
+	switch opts.Normalization {
+	case NFC:
+		return norm.NFC.String(name)
+	case NFD:
+		return norm.NFD.String(name)
+	}
+	return name
+}
+
+// nameKey returns the key of a filename, which is the same for all the
+// names which are considered equal on the destination.
+func (opts Options) nameKey(name string) string {
+	name = opts.normalize(name)
+	if opts.caseInsensitive() {
+		// The folding might change the normalization, so that every
+		// name is normalized again:
+		name = opts.normalize(cases.Fold().String(name))
+	}
+	return name
+}
+
+// foldingOS wraps the filesystem of a Flattener, if the destination
+// folds the names (see Options.CaseInsensitive and Options.Normalization).
+// Exists then also finds the files, whose names are only equal to the
+// given one after folding, which a simulated filesystem does not do by
+// itself.
+type foldingOS struct {
+	osabstraction.OSWrapper
+	key func(name string) string
+	// dirs maps the directories, which have been indexed, to the
+	// number of their entries per key.
+	dirs map[string]map[string]int
+}
+
+// newFoldingOS returns osw wrapped according to opts.
+func newFoldingOS(osw osabstraction.OSWrapper, opts Options) *foldingOS {
+	return &foldingOS{OSWrapper: osw, key: opts.nameKey, dirs: map[string]map[string]int{}}
+}
+
+// index returns the number of entries per key in the directory dir,
+// which gets indexed on first use.
+func (fos *foldingOS) index(dir string) map[string]int {
+	keys, indexed := fos.dirs[dir]
+	if indexed {
+		return keys
+	}
+	keys = map[string]int{}
+	entries, _ := osabstraction.List(fos.OSWrapper, dir)
+	for _, fi := range entries {
+		keys[fos.key(fi.Name())]++
+	}
+	fos.dirs[dir] = keys
+	return keys
+}
+
+// Exists returns true if a file, whose name folds to the same key as
+// the one of p, exists in the directory of p.
+func (fos *foldingOS) Exists(p string) bool {
+	p = filepath.Clean(p)
+	if fos.OSWrapper.Exists(p) {
+		return true
+	}
+	if p == "/" || !fos.OSWrapper.IsDirectory(filepath.Dir(p)) {
+		return false
+	}
+	return fos.index(filepath.Dir(p))[fos.key(filepath.Base(p))] > 0
+}
+
+// List returns the files and the directories located directly in dir.
+func (fos *foldingOS) List(dir string) ([]osabstraction.FileInfo, error) {
+	return osabstraction.List(fos.OSWrapper, dir)
+}
+
+// added records the new file p in the index of its directory.
+func (fos *foldingOS) added(p string) {
+	if keys, indexed := fos.dirs[filepath.Dir(p)]; indexed {
+		keys[fos.key(filepath.Base(p))]++
+	}
+}
+
+// Copy copies the file src to dst.
+func (fos *foldingOS) Copy(src, dst string) error {
+	err := fos.OSWrapper.Copy(src, dst)
+	if err == nil {
+		fos.added(filepath.Clean(dst))
+	}
+	return err
+}
+
+// Move moves the file src to dst.
+func (fos *foldingOS) Move(src, dst string) error {
+	err := fos.OSWrapper.Move(src, dst)
+	if err != nil {
+		return err
+	}
+	src = filepath.Clean(src)
+	if keys, indexed := fos.dirs[filepath.Dir(src)]; indexed {
+		keys[fos.key(filepath.Base(src))]--
+	}
+	fos.added(filepath.Clean(dst))
+	return nil
+}
+
+// RemoveSubDirectories removes all the subdirectories of p and forgets
+// the indices, which might be outdated then.
+func (fos *foldingOS) RemoveSubDirectories(p string) error {
+	fos.dirs = map[string]map[string]int{}
+	return fos.OSWrapper.RemoveSubDirectories(p)
+}
@@ -0,0 +1,138 @@
+package flatten
+
+import (
+	"fmt"
+	"io"
+)
+
+// Operation describes the relocation of a single file from
+// Source to Destination.
+type Operation struct {
+	Source      string
+	Destination string
+	// Copy indicates that the file gets copied instead of moved.
+	Copy bool
+	// Truncated indicates that the name of the file has been shortened,
+	// since it is longer than the limit of the destination.
+	Truncated bool
+}
+
+// Observer gets notified about the progress of a flattening
+// process. Observers are registered with Flattener.AddObserver.
+type Observer interface {
+	// OnPlanned gets called once, after all the operations have
+	// been planned, but before any of them is performed.
+	OnPlanned(ops []Operation)
+	// OnFileStart gets called right before op is performed.
+	OnFileStart(op Operation)
+	// OnFileDone gets called after op has been performed successfully.
+	OnFileDone(op Operation)
+	// OnSkip gets called if op is not performed, because there
+	// is nothing to do.
+	OnSkip(op Operation, reason string)
+	// OnError gets called if op could not be performed.
+	OnError(op Operation, err error)
+	// OnDirRemoved gets called for every subdirectory of the source
+	// directory, which has been removed after moving the files.
+	OnDirRemoved(dir string)
+	// OnFinish gets called once at the end of the process. err is
+	// the error returned by Flatten.
+	OnFinish(err error)
+}
+
+// BaseObserver implements the Observer interface by doing nothing.
+// It can be embedded into types which only want to implement some
+// of the Observer methods.
+type BaseObserver struct{}
+
+// OnPlanned does nothing.
+func (BaseObserver) OnPlanned(ops []Operation) {}
+
+// OnFileStart does nothing.
+func (BaseObserver) OnFileStart(op Operation) {}
+
+// OnFileDone does nothing.
+func (BaseObserver) OnFileDone(op Operation) {}
+
+// OnSkip does nothing.
+func (BaseObserver) OnSkip(op Operation, reason string) {}
+
+// OnError does nothing.
+func (BaseObserver) OnError(op Operation, err error) {}
+
+// OnDirRemoved does nothing.
+func (BaseObserver) OnDirRemoved(dir string) {}
+
+// OnFinish does nothing.
+func (BaseObserver) OnFinish(err error) {}
+
+// LogObserver writes a line for every action of the flattening
+// process to W. This is what the verbose option uses.
+type LogObserver struct {
+	BaseObserver
+	W io.Writer
+}
+
+// OnFileStart reports that a file is being copied or moved.
+func (lo LogObserver) OnFileStart(op Operation) {
+	if op.Copy {
+		fmt.Fprintf(lo.W, "Copying %v to %v\n", op.Source, op.Destination)
+	} else {
+		fmt.Fprintf(lo.W, "Moving %v to %v\n", op.Source, op.Destination)
+	}
+}
+
+// OnSkip reports that a file has been skipped.
+func (lo LogObserver) OnSkip(op Operation, reason string) {
+	fmt.Fprintf(lo.W, "Skipping %v: %v\n", op.Source, reason)
+}
+
+// OnDirRemoved reports that a directory has been removed.
+func (lo LogObserver) OnDirRemoved(dir string) {
+	fmt.Fprintf(lo.W, "Removed directory %v\n", dir)
+}
+
+// observers dispatches every call to all of its elements.
+type observers []Observer
+
+func (obs observers) OnPlanned(ops []Operation) {
+	for _, o := range obs {
+		o.OnPlanned(ops)
+	}
+}
+
+func (obs observers) OnFileStart(op Operation) {
+	for _, o := range obs {
+		o.OnFileStart(op)
+	}
+}
+
+func (obs observers) OnFileDone(op Operation) {
+	for _, o := range obs {
+		o.OnFileDone(op)
+	}
+}
+
+func (obs observers) OnSkip(op Operation, reason string) {
+	for _, o := range obs {
+		o.OnSkip(op, reason)
+	}
+}
+
+func (obs observers) OnError(op Operation, err error) {
+	for _, o := range obs {
+		o.OnError(op, err)
+	}
+}
+
+func (obs observers) OnDirRemoved(dir string) {
+	for _, o := range obs {
+		o.OnDirRemoved(dir)
+	}
+}
+
+func (obs observers) OnFinish(err error) {
+	for _, o := range obs {
+		o.OnFinish(err)
+	}
+}
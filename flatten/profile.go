@@ -0,0 +1,200 @@
+package flatten
+
+import (
+	"path"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Profile is the kind of filesystem of the destination, whose rules the
+// names of the flattened files have to follow (see Options.Profile).
+type Profile string
+
+// The supported target profiles:
+const (
+	// ProfilePOSIX only forbids the slash and limits the names to 255
+	// bytes.
+	ProfilePOSIX Profile = "posix"
+	// ProfileVFAT are the rules of FAT32 USB sticks.
+	ProfileVFAT Profile = "vfat"
+	// ProfileExFAT are the rules of exFAT USB sticks and SD cards.
+	ProfileExFAT Profile = "exfat"
+	// ProfileNTFS are the rules of NTFS as seen by Windows.
+	ProfileNTFS Profile = "ntfs"
+	// ProfileSMB are the rules of SMB shares of Windows and Samba.
+	ProfileSMB Profile = "smb"
+)
+
+// Profiles contains all the supported target profiles.
+var Profiles = []Profile{ProfilePOSIX, ProfileVFAT, ProfileExFAT, ProfileNTFS, ProfileSMB}
+
+// nameRules are the restrictions of the names of a target profile.
+type nameRules struct {
+	// forbidden are the characters, which get replaced. The control
+	// characters are forbidden as well, if control is true.
+	forbidden string
+	control   bool
+	// trailing are the characters, which must not end a name.
+	trailing string
+	// reserved indicates that the DOS device names like CON or LPT1
+	// must not be used, not even with an extension.
+	reserved bool
+	// maxLength is the maximum length of a name, which is measured in
+	// UTF-16 code units if utf16 is true, and in bytes otherwise.
+	maxLength int
+	utf16     bool
+	// maxBytes is the maximum length of a name in bytes, which applies
+	// in addition to maxLength (see Options.MaxNameBytes).
+	maxBytes int
+	// caseInsensitive indicates that names, which only differ by their
+	// case, collide.
+	caseInsensitive bool
+}
+
+// windowsRules are the rules of the Windows API, which apply to all the
+// filesystems used by Windows.
+var windowsRules = nameRules{
+	forbidden:       `"*/:<>?\|`,
+	control:         true,
+	trailing:        ". ",
+	reserved:        true,
+	maxLength:       255,
+	utf16:           true,
+	caseInsensitive: true,
+}
+
+// profileRules maps the target profiles to their rules. Without a
+// profile, the names are not restricted.
+var profileRules = map[Profile]nameRules{
+	"":           {},
+	ProfilePOSIX: {forbidden: "/", maxLength: 255},
+	ProfileVFAT:  windowsRules,
+	ProfileExFAT: windowsRules,
+	ProfileNTFS:  windowsRules,
+	ProfileSMB:   windowsRules,
+}
+
+// replacement replaces the characters, which are not allowed in a name.
+const replacement = '_'
+
+// reservedNames are the DOS device names.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// length returns the length of s in the unit of the rules.
+func (r nameRules) length(s string) int {
+	if !r.utf16 {
+		return len(s)
+	}
+	n := 0
+	for _, c := range s {
+		n += utf16.RuneLen(c)
+	}
+	return n
+}
+
+// fits returns true if s is not longer than the maximum length and the
+// maximum number of bytes of the rules.
+func (r nameRules) fits(s string) bool {
+	return (r.maxLength <= 0 || r.length(s) <= r.maxLength) && (r.maxBytes <= 0 || len(s) <= r.maxBytes)
+}
+
+// truncate shortens s from the end without cutting a character in
+// half, until fits returns true for it.
+func truncate(s string, fits func(s string) bool) string {
+	for s != "" && !fits(s) {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
+
+// sanitize replaces the characters of name, which are not allowed. The
+// reserved names are avoided by reserve and the length is not limited.
+func (r nameRules) sanitize(name string) string {
+	name = strings.Map(func(c rune) rune {
+		if strings.ContainsRune(r.forbidden, c) || r.control && (c < 0x20 || c == 0x7f) {
+			return replacement
+		}
+		return c
+	}, name)
+	trimmed := strings.TrimRight(name, r.trailing)
+	name = trimmed + strings.Repeat(string(replacement), len(name)-len(trimmed))
+	if name == "" {
+		name = string(replacement)
+	}
+	return name
+}
+
+// reserve avoids the reserved names by appending a replacement to the
+// stem of name. It is applied to the final name, since the appendix of
+// a numbered name already makes its stem differ from a reserved name.
+func (r nameRules) reserve(name string) string {
+	if !r.reserved {
+		return name
+	}
+	// The part before the first dot is what DOS considers the name:
+	stem, rest := name, ""
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		stem, rest = name[:i], name[i:]
+	}
+	if reservedNames[strings.ToUpper(stem)] {
+		return stem + string(replacement) + rest
+	}
+	return name
+}
+
+// filename returns the name of the file with the index among the
+// files of the same name, whose appendix has the given length (see
+// generateFilename), so that it follows the rules. If the name is too
+// long, its base name is shortened and then its extension, so that the
+// appendix is always kept and the names of different indices differ.
+// truncated is true if the name has been shortened.
+func (r nameRules) filename(oldFilename string, index int, length int) (name string, truncated bool) {
+	sanitized := r.sanitize(oldFilename)
+	name = generateFilename(sanitized, index, length)
+	if r.fits(r.reserve(name)) {
+		return r.reserve(name), false
+	}
+	base, ext := baseName(sanitized), path.Ext(sanitized)
+	appendix := strings.TrimSuffix(strings.TrimPrefix(name, base), ext)
+	// A name without an appendix keeps a single character of its base
+	// name, so that it does not become a hidden file:
+	kept := appendix
+	if kept == "" {
+		kept = string(replacement)
+	}
+	ext = truncate(ext, func(ext string) bool { return r.fits(kept + ext) })
+	base = truncate(base, func(base string) bool { return r.fits(r.reserve(base + appendix + ext)) })
+	if base == "" && appendix == "" {
+		base = string(replacement)
+	}
+	// The shortened name must not end with a forbidden character:
+	return r.reserve(r.sanitize(base + appendix + ext)), true
+}
+
+// rules returns the rules of the target profile, which are restricted
+// by the maximum number of bytes of a name.
+func (opts Options) rules() nameRules {
+	rules := profileRules[opts.Profile]
+	switch {
+	case opts.MaxNameBytes == 0:
+		rules.maxBytes = DefaultMaxNameBytes
+	case opts.MaxNameBytes > 0:
+		rules.maxBytes = opts.MaxNameBytes
+	}
+	return rules
+}
+
+// filename returns the name of the flattened file on the destination
+// (see nameRules.filename), which is normalized and follows the rules
+// of the target profile and the maximum number of bytes.
+func (opts Options) filename(oldFilename string, index int, length int) (string, bool) {
+	return opts.rules().filename(opts.normalize(oldFilename), index, length)
+}
@@ -2,19 +2,45 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
-	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	docopt "github.com/docopt/docopt-go"
 	"github.com/goggle/flatten/filesystem"
 	"github.com/goggle/flatten/flatten"
 	"github.com/goggle/flatten/osabstraction"
+	"golang.org/x/term"
 )
 
 const version = "0.8.0"
 
+// Exit codes of the program:
+const (
+	exitSuccess          = 0
+	exitFailure          = 1
+	exitPartialFailure   = 2
+	exitDeclined         = 3
+	exitInvalidArguments = 4
+	exitNothingToDo      = 5
+)
+
+// exit writes the message to stderr and terminates the program
+// with the given exit code.
+func exit(code int, a ...interface{}) {
+	fmt.Fprintln(os.Stderr, a...)
+	os.Exit(code)
+}
+
+// stdinIsTerminal returns true if the standard input is connected
+// to a terminal, so that we can ask the user questions.
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
 func ask(question string, defaultYes bool) bool {
 	var defaultString string
 	if defaultYes {
@@ -23,7 +49,7 @@ func ask(question string, defaultYes bool) bool {
 		defaultString = "y/[n]:"
 	}
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Printf(question + " " + defaultString + " ")
+	fmt.Fprint(os.Stderr, question+" "+defaultString+" ")
 	test, _ := reader.ReadString('\n')
 	test = strings.Trim(test, "\n ")
 	if defaultYes {
@@ -38,51 +64,154 @@ func ask(question string, defaultYes bool) bool {
 	return false
 }
 
-func simulate(sourceFI osabstraction.FileInfo, destinationFI osabstraction.FileInfo, copyOnly bool, includeSourceFiles bool) (string, error) {
-	fs := filesystem.Filesystem{}
-	fs.Init()
-	err := fs.AddFromRealFilesystem(sourceFI.FullPath())
-	if err != nil {
-		return "", err
-	}
-	err = fs.AddFromRealFilesystem(destinationFI.FullPath())
-	if err != nil {
-		return "", err
-	}
-	err = flatten.Flatten(sourceFI, destinationFI, fs, copyOnly, includeSourceFiles)
-	if err != nil {
-		return "", err
+// validProfile returns true if profile is one of the supported
+// target profiles.
+func validProfile(profile flatten.Profile) bool {
+	for _, p := range flatten.Profiles {
+		if p == profile {
+			return true
+		}
 	}
-	tree := filesystem.Tree{}
-	tree.Create(destinationFI, fs)
-	return fmt.Sprintf("%v", tree), nil
+	return false
+}
+
+// activity is a flatten.Observer, which records whether a flattening
+// process has performed (or attempted) any operations or removed any
+// directories. Skipped operations do not count.
+type activity struct {
+	flatten.BaseObserver
+	performed int
+	removed   int
+}
+
+func (a *activity) OnFileDone(op flatten.Operation) {
+	a.performed++
+}
+
+func (a *activity) OnError(op flatten.Operation, err error) {
+	a.performed++
+}
+
+func (a *activity) OnDirRemoved(dir string) {
+	a.removed++
+}
+
+// nothingDone returns true if the process has neither copied or moved
+// any files nor removed any directories.
+func (a *activity) nothingDone() bool {
+	return a.performed == 0 && a.removed == 0
 }
 
 func main() {
 	usage := `flatten.
 
 Usage:
-  flatten [SOURCE] [DESTINATION] [-c | --copy-only] [-f | --force] [--include-source-files] [-s | --simulate-only] [--verbose]
+  flatten tree [PATH] [--tree-format=FORMAT] [--max-depth=N] [--max-children=N] [--pattern=GLOB] [--dirs-only] [--color=WHEN]
+  flatten [SOURCE] [DESTINATION] [-c | --copy-only] [-f | --force] [--include-source-files] [--case-insensitive] [--normalize=FORM] [--target-profile=PROFILE] [--max-name-bytes=N] [-s | --simulate-only] [--simulate-from=LISTING] [--listing-format=FORMAT] [-k | --keep-going] [-y | --yes] [--no-input] [--output=FORMAT] [--preview=MODE] [--color=WHEN] [--tree-format=FORMAT] [--max-depth=N] [--max-children=N] [--pattern=GLOB] [--dirs-only] [--verbose] [--progress]
   flatten -h | --help
   flatten -v
 
 Recursively flatten the directory structure from SOURCE to DESTINATION.
+The tree command shows the directory tree of PATH.
 
 Arguments:
   SOURCE                    Optional source directory (default is current directory).
   DESTINATION               Optional destination directory (default is current directory).
+  PATH                      Optional directory for the tree command (default is current directory).
 
 Options:
   -c --copy-only            Do not remove anything from the source directory.
   -f --force                Do not propose a simulation first, immediately execute the command.
   --include-source-files    Include the files which are directly located in the SOURCE directory.
+  --case-insensitive        Names which only differ by their case collide in DESTINATION and get
+                            numbered, like on vfat, exFAT or SMB. This is detected automatically
+                            for these filesystems and for casefolded directories on Linux.
+  --normalize=FORM          Convert the names of the files to the Unicode normalization form
+                            FORM (nfc or nfd), so that names which only differ by their
+                            normalization collide and get numbered.
+  --target-profile=PROFILE  Make the names of the files valid on a DESTINATION of the kind PROFILE:
+                            posix, vfat, exfat, ntfs or smb. Forbidden characters and trailing
+                            dots or spaces are replaced by underscores, reserved names like CON
+                            get an underscore appended, and too long names are shortened. Names
+                            which become equal collide and get numbered.
+  --max-name-bytes=N        Shorten the names of the files to at most N bytes, keeping their
+                            extension and their number, 0 means no limit [default: 255].
+  -k --keep-going           Continue with the remaining files if a file cannot be moved or copied,
+                            and report all the failures at the end (exit code 2).
   -s --simulate-only        Do not move or copy any files on the system,
                             just output the expected result.
+  --simulate-from=LISTING   Simulate the process on the files of the LISTING file instead of
+                            the files on the system, for example of a tree on another machine.
+                            Implies --simulate-only.
+  --listing-format=FORMAT   Format of the listing: auto, find, nul, json or csv
+                            [default: auto]. The find format contains the output of find with
+                            absolute paths, optionally printed with -printf '%y %s %T@ %m %p\n'.
+                            The nul format contains absolute paths separated by NUL characters
+                            (find -print0). The json and csv formats are manifests with the
+                            fields path, type, size, mtime and mode, of which only path is
+                            required. A snapshot of a simulated filesystem is read as well.
+                            The auto mode guesses the format from the name and the content.
+  -y --yes                  Answer all the questions with yes.
+  --no-input                Never read from the standard input. Without --yes or --force,
+                            the process is declined. This is the default if the standard
+                            input is not a terminal.
+  -o --output=FORMAT        Output format: text, json or ndjson [default: text].
+                            With json, the simulation is written as a JSON document.
+                            With ndjson, an event is written per line, starting
+                            with the plan and continuing while the files are
+                            being moved or copied.
+  --preview=MODE            How the simulation is shown in the text output: changes lists
+                            the files and directories which change, tree shows the whole
+                            resulting destination tree, side-by-side shows the source
+                            tree next to the destination and links every file with its
+                            new location [default: changes].
+  --color=WHEN              Color the text output: auto, always or never [default: auto].
+                            In the auto mode, colors are only used on a terminal and
+                            if the NO_COLOR environment variable is not set.
+  --tree-format=FORMAT      Format of the tree: text, json (like tree -J), html, markdown
+                            or dot [default: text].
+  --max-depth=N             Only show the tree up to depth N, 0 means no limit [default: 0].
+  --max-children=N          Only show the first N entries of every directory in the tree and
+                            collapse the remaining ones, 0 means no limit [default: 0].
+  --pattern=GLOB            Only show the files in the tree which match the shell pattern GLOB,
+                            and the directories containing them.
+  --dirs-only               Only show the directories in the tree.
   --verbose                 Explain what is being done.
+  --progress                Show a progress bar while the files are being moved or copied.
   -v --version              Show version.
-  -h --help                 Show this screen.`
+  -h --help                 Show this screen.
 
-	arguments, _ := docopt.Parse(usage, nil, true, "flatten "+version, false)
+Exit codes:
+  0                         Success.
+  1                         An error occurred.
+  2                         Some files could not be moved or copied (see --keep-going).
+  3                         The process has been declined.
+  4                         Invalid arguments.
+  5                         Nothing to do.`
+
+	parser := &docopt.Parser{
+		HelpHandler: func(err error, usage string) {
+			if err != nil {
+				exit(exitInvalidArguments, usage)
+			}
+			fmt.Println(usage)
+			os.Exit(exitSuccess)
+		},
+	}
+	arguments, _ := parser.ParseArgs(usage, nil, "flatten "+version)
+
+	colorWhen := arguments["--color"].(string)
+	if colorWhen != colorAuto && colorWhen != colorAlways && colorWhen != colorNever {
+		exit(exitInvalidArguments, "Invalid color mode: "+colorWhen)
+	}
+	renderOpts := renderOptions(arguments)
+	renderOpts.Color = useColor(colorWhen)
+	format := treeFormat(arguments)
+
+	if arguments["tree"].(bool) {
+		runTree(arguments, format, renderOpts)
+		return
+	}
 
 	var source string
 	var destination string
@@ -91,8 +220,7 @@ Options:
 	if src == nil {
 		p, err := os.Getwd()
 		if err != nil {
-			fmt.Printf("%v\n", err)
-			return
+			exit(exitFailure, err)
 		}
 		source = p
 	} else {
@@ -103,37 +231,109 @@ Options:
 	if dst == nil {
 		p, err := os.Getwd()
 		if err != nil {
-			fmt.Printf("%v\n", err)
-			return
+			exit(exitFailure, err)
 		}
 		destination = p
 	} else {
 		destination = dst.(string)
 	}
 
-	verbose := arguments["--verbose"].(bool)
-	if verbose {
-		flatten.SetVerbose()
+	opts := flatten.Options{
+		CopyOnly:         arguments["--copy-only"].(bool),
+		IncludeBaseFiles: arguments["--include-source-files"].(bool),
+		Verbose:          arguments["--verbose"].(bool),
+		KeepGoing:        arguments["--keep-going"].(bool),
+		CaseInsensitive:  arguments["--case-insensitive"].(bool),
+	}
+	if profile := arguments["--target-profile"]; profile != nil {
+		opts.Profile = flatten.Profile(profile.(string))
+		if !validProfile(opts.Profile) {
+			exit(exitInvalidArguments, "Invalid target profile: "+profile.(string))
+		}
+	}
+	maxNameBytes, err := strconv.Atoi(arguments["--max-name-bytes"].(string))
+	if err != nil || maxNameBytes < 0 {
+		exit(exitInvalidArguments, "Invalid maximal length of names: "+arguments["--max-name-bytes"].(string))
+	}
+	opts.MaxNameBytes = maxNameBytes
+	if maxNameBytes == 0 {
+		opts.MaxNameBytes = -1
+	}
+	if normalization := arguments["--normalize"]; normalization != nil {
+		opts.Normalization = flatten.Normalization(normalization.(string))
+		if opts.Normalization != flatten.NFC && opts.Normalization != flatten.NFD {
+			exit(exitInvalidArguments, "Invalid normalization form: "+normalization.(string))
+		}
+	}
+	output := arguments["--output"].(string)
+	if output != outputText && output != outputJSON && output != outputNDJSON {
+		exit(exitInvalidArguments, "Invalid output format: "+output)
+	}
+	// In the ndjson mode, every line written to stdout is an event,
+	// starting with the planned event of the simulation:
+	events := newEventStream(os.Stdout)
+	preview := arguments["--preview"].(string)
+	if preview != previewChanges && preview != previewTree && preview != previewSide {
+		exit(exitInvalidArguments, "Invalid preview mode: "+preview)
 	}
-
-	includeSourceFiles := arguments["--include-source-files"].(bool)
-	copyOnly := arguments["--copy-only"].(bool)
 	simulateOnly := arguments["--simulate-only"].(bool)
 	force := arguments["--force"].(bool)
+	assumeYes := arguments["--yes"].(bool)
+	interactive := !arguments["--no-input"].(bool) && stdinIsTerminal()
 	performSimulation := false
 	askSecondQuestion := true
 
-	sourceFI := osabstraction.File(path.Clean(source))
-	destinationFI := osabstraction.File(path.Clean(destination))
+	// The simulated filesystems only know absolute paths:
+	source, err = filepath.Abs(source)
+	if err != nil {
+		exit(exitFailure, err)
+	}
+	destination, err = filepath.Abs(destination)
+	if err != nil {
+		exit(exitFailure, err)
+	}
+	sourceFI := osabstraction.File(source)
+	destinationFI := osabstraction.File(destination)
+
+	// With a listing, the process is only simulated on its files:
+	var listing filesystem.Filesystem
+	var osWrapper osabstraction.OSWrapper = osabstraction.RealOS{}
+	if listingFile := arguments["--simulate-from"]; listingFile != nil {
+		listingFormat := arguments["--listing-format"].(string)
+		if !validListingFormat(listingFormat) {
+			exit(exitInvalidArguments, "Invalid listing format: "+listingFormat)
+		}
+		listing, err = readListing(listingFile.(string), listingFormat, source, destination)
+		if err != nil {
+			exit(exitFailure, "Could not read the listing: "+err.Error())
+		}
+		osWrapper = listing
+		simulateOnly = true
+	} else if !opts.CaseInsensitive {
+		opts.CaseInsensitive = osabstraction.IsCaseInsensitive(destination)
+	}
+
+	for _, fi := range []osabstraction.FileInfo{sourceFI, destinationFI} {
+		if !osWrapper.IsDirectory(fi.FullPath()) {
+			exit(exitInvalidArguments, fmt.Errorf("%v is %w", fi.FullPath(), flatten.ErrNotDirectory))
+		}
+	}
 
 	if simulateOnly {
 		performSimulation = true
 	}
 
+	// Without a terminal (or with --no-input), we cannot ask the user
+	// for a confirmation, so we refuse to change anything unless the
+	// user explicitly agreed in advance:
+	if !force && !simulateOnly && !assumeYes && !interactive {
+		exit(exitDeclined, "Flatten performs changes on the file system, but cannot ask for a confirmation. Use --yes or --force to proceed.")
+	}
+
 	// Propose to do a simulation first as long we are not in the
 	// "force" or "simulation-only" mode:
 	if !force && !simulateOnly {
-		anw := ask("Flatten performs changes on the file system. Do you want to simulate this process first?", true)
+		anw := assumeYes || ask("Flatten performs changes on the file system. Do you want to simulate this process first?", true)
 		if anw {
 			performSimulation = true
 		} else {
@@ -143,35 +343,86 @@ Options:
 	}
 
 	if performSimulation {
-		treeString, err := simulate(sourceFI, destinationFI, copyOnly, includeSourceFiles)
+		sim, err := simulate(sourceFI, destinationFI, opts, listing, os.Stderr)
 		if err != nil {
-			fmt.Println("Could not simulate the process. The following error occured:")
-			fmt.Println(err)
-			os.Exit(1)
+			exit(exitFailure, "Could not simulate the process. The following error occured:\n"+err.Error())
+		}
+		if sim.nothingToDo() {
+			exit(exitNothingToDo, "Nothing to do.")
+		}
+		if output == outputText {
+			switch preview {
+			case previewTree:
+				err = writeTree(os.Stdout, sim, format, renderOpts)
+			case previewSide:
+				err = writeSideBySide(os.Stdout, sim, renderOpts, terminalWidth())
+			default:
+				err = writeChanges(os.Stdout, sim, renderOpts.Color)
+			}
+			if err != nil {
+				exit(exitFailure, err)
+			}
+			if sim.err != nil {
+				fmt.Fprintln(os.Stderr, "The following failures are predicted:")
+				fmt.Fprintln(os.Stderr, sim.err)
+			}
+		} else if output == outputNDJSON {
+			err = events.emitSimulation(sim)
+			if err != nil {
+				exit(exitFailure, err)
+			}
+		} else {
+			err = writeSimulationJSON(os.Stdout, sim)
+			if err != nil {
+				exit(exitFailure, err)
+			}
+		}
+		// Do not propose a process, which is predicted to fail:
+		if sim.err != nil && !opts.KeepGoing {
+			exit(exitFailure, "The process cannot be completed. Use --keep-going to skip the failing files.")
 		}
-		fmt.Printf(treeString)
 	}
 
 	// If we are in "simulation-only" mode, we can exit the program
 	// at this point.
 	if simulateOnly {
-		os.Exit(0)
+		os.Exit(exitSuccess)
 	}
 
 	// Ask the user to continue the process as long as we are not in
 	// the "force" mode.
 	if !force && askSecondQuestion {
-		anw := ask("The above changes will be performed. Do you want to continue?", false)
+		anw := assumeYes || ask("The above changes will be performed. Do you want to continue?", false)
 		if !anw {
-			os.Exit(0)
+			os.Exit(exitDeclined)
 		}
 	}
 
 	// Perform the flattening process on the real filesystem:
-	osWrapper := osabstraction.RealOS{}
-	err := flatten.Flatten(sourceFI, destinationFI, osWrapper, copyOnly, includeSourceFiles)
-	if err != nil {
-		fmt.Printf("%v\n", err)
-		os.Exit(1)
+	flattener := flatten.New(osabstraction.RealOS{}, opts)
+	flattener.SetOutput(os.Stderr)
+	if output == outputNDJSON {
+		flattener.AddObserver(events)
+	}
+	if arguments["--progress"].(bool) {
+		flattener.AddObserver(&progressBar{w: os.Stderr})
+	}
+	act := &activity{}
+	flattener.AddObserver(act)
+	err = flattener.Flatten(sourceFI, destinationFI)
+	if events.err != nil {
+		exit(exitFailure, "Could not write the events: "+events.err.Error())
+	}
+	var multiErr *flatten.MultiError
+	if errors.As(err, &multiErr) {
+		fmt.Fprintf(os.Stderr, "%v file operation(s) failed:\n", len(multiErr.Errors))
+		for _, e := range multiErr.Errors {
+			fmt.Fprintf(os.Stderr, "  %v\n", e)
+		}
+		os.Exit(exitPartialFailure)
+	} else if err != nil {
+		exit(exitFailure, err)
+	} else if act.nothingDone() {
+		exit(exitNothingToDo, "Nothing to do.")
 	}
 }
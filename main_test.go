@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/goggle/flatten/filesystem"
+	"github.com/goggle/flatten/flatten"
+)
+
+func TestActivity(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		opts    flatten.Options
+		nothing bool
+	}{
+		{"empty", []string{"/dst/x"}, flatten.Options{}, true},
+		{"files", []string{"/src/a/x", "/dst/y"}, flatten.Options{}, false},
+		{"empty directories", []string{"/src/a/b/", "/dst/y"}, flatten.Options{}, false},
+		{"empty directories when copying", []string{"/src/a/b/", "/dst/y"}, flatten.Options{CopyOnly: true}, true},
+	}
+	for _, test := range tests {
+		fs := filesystem.Filesystem{}
+		fs.Init()
+		fs.MkDir("/src")
+		for _, p := range test.files {
+			if p[len(p)-1] == '/' {
+				fs.MkDir(p[:len(p)-1])
+			} else {
+				fs.CreateFile(p)
+			}
+		}
+		act := &activity{}
+		flattener := flatten.New(fs, test.opts)
+		flattener.AddObserver(act)
+		err := flattener.Flatten(filesystem.DummyFile{Path: "/src", IsDirectory: true}, filesystem.DummyFile{Path: "/dst", IsDirectory: true})
+		if err != nil {
+			t.Fatalf("%v: no error expected, got %v", test.name, err)
+		}
+		if act.nothingDone() != test.nothing {
+			t.Errorf("%v: expected nothingDone to be %v", test.name, test.nothing)
+		}
+	}
+
+	// Planned, but skipped operations do not count:
+	act := &activity{}
+	op := flatten.Operation{Source: "/dst/x", Destination: "/dst/x"}
+	act.OnPlanned([]flatten.Operation{op})
+	act.OnSkip(op, "source and destination are identical")
+	if !act.nothingDone() {
+		t.Errorf("skipped: expected nothingDone to be true")
+	}
+}
@@ -0,0 +1,39 @@
+package osabstraction
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsCasefoldFl is the attribute of a casefolded directory on ext4
+// and f2fs (see chattr(1)), which golang.org/x/sys/unix does not
+// define.
+const fsCasefoldFl = 0x40000000
+
+// IsCaseInsensitive returns true if the directory dir does not
+// distinguish the names of its entries by case, since it is located
+// on a vfat, exFAT or SMB filesystem, or since it is a casefolded
+// directory of ext4 or f2fs.
+func IsCaseInsensitive(dir string) bool {
+	var st unix.Statfs_t
+	if unix.Statfs(dir, &st) == nil {
+		switch uint32(st.Type) {
+		case unix.MSDOS_SUPER_MAGIC, unix.EXFAT_SUPER_MAGIC, unix.SMB_SUPER_MAGIC, unix.SMB2_SUPER_MAGIC, unix.CIFS_SUPER_MAGIC:
+			return true
+		}
+	}
+	return casefolded(dir)
+}
+
+// casefolded returns true if the directory dir has the casefold
+// attribute.
+func casefolded(dir string) bool {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	flags, err := unix.IoctlGetUint32(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	return err == nil && flags&fsCasefoldFl != 0
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package osabstraction
+
+// IsCaseInsensitive returns true if the directory dir does not
+// distinguish the names of its entries by case. It is only detected
+// on Linux, so false is returned on the other operating systems.
+func IsCaseInsensitive(dir string) bool {
+	return false
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -22,6 +23,41 @@ type OSWrapper interface {
 	RemoveSubDirectories(p string) error
 }
 
+// Lister is implemented by the OSWrappers, which can list the entries
+// of a single directory without walking its whole subtree.
+type Lister interface {
+	// List returns the files and the directories located directly
+	// in dir sorted by their names.
+	List(dir string) ([]FileInfo, error)
+}
+
+// List returns the files and the directories located directly in dir
+// on w sorted by their names. If w does not implement Lister, the
+// whole subtree of dir gets walked.
+func List(w OSWrapper, dir string) ([]FileInfo, error) {
+	if l, ok := w.(Lister); ok {
+		return l.List(dir)
+	}
+	files, err := w.GetFiles(dir, true)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := w.GetDirectories(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := []FileInfo{}
+	for _, fi := range append(files, dirs...) {
+		if fi.Directory() == path.Clean(dir) {
+			entries = append(entries, fi)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
 // FileInfo represents all the relevant information about a file
 // which might be on a real file system or a fake one.
 type FileInfo interface {
@@ -86,6 +122,16 @@ func (f File) BaseName() string {
 	return filename[:j]
 }
 
+// Size returns the size of the file f in bytes. If the file
+// does not exist, 0 gets returned.
+func (f File) Size() int64 {
+	fi, err := os.Lstat(f.FullPath())
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
 // Level returns the depth of the file in the filesystem tree.
 // The root path has level 0.
 func (f File) Level() int {
@@ -190,6 +236,20 @@ func (ros RealOS) GetDirectories(dir string) ([]FileInfo, error) {
 	return dirs, nil
 }
 
+// List returns the files and the directories located directly
+// in dir sorted by their names.
+func (ros RealOS) List(dir string) ([]FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, 0, len(entries))
+	for _, e := range entries {
+		files = append(files, File(filepath.Join(dir, e.Name())))
+	}
+	return files, nil
+}
+
 // IsRegularFile returns true if a file at path p
 // is a file but not a directory, otherwise false
 func (ros RealOS) IsRegularFile(p string) bool {
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/goggle/flatten/filesystem"
+	"github.com/goggle/flatten/flatten"
+)
+
+// schemaVersion is the version of the JSON and NDJSON output. It gets
+// increased whenever a field is removed or changes its meaning.
+const schemaVersion = 1
+
+// Output formats:
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+)
+
+// jsonOperation is the JSON representation of a flatten.Operation.
+type jsonOperation struct {
+	Action      string `json:"action"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Truncated   bool   `json:"truncated,omitempty"`
+}
+
+func newJSONOperation(op flatten.Operation) jsonOperation {
+	action := "move"
+	if op.Copy {
+		action = "copy"
+	}
+	return jsonOperation{Action: action, Source: op.Source, Destination: op.Destination, Truncated: op.Truncated}
+}
+
+// jsonStatistics summarizes a simulation.
+type jsonStatistics struct {
+	Files              int `json:"files"`
+	Renamed            int `json:"renamed"`
+	Truncated          int `json:"truncated"`
+	RemovedDirectories int `json:"removed_directories"`
+	Failures           int `json:"failures"`
+}
+
+// jsonSimulation is the JSON representation of a simulation.
+type jsonSimulation struct {
+	Version            int             `json:"version"`
+	Source             string          `json:"source"`
+	Destination        string          `json:"destination"`
+	Plan               []jsonOperation `json:"plan"`
+	RemovedDirectories []string        `json:"removed_directories"`
+	Tree               filesystem.Tree `json:"tree"`
+	Statistics         jsonStatistics  `json:"statistics"`
+	Failures           []string        `json:"failures"`
+}
+
+// writeSimulationJSON writes the simulation sim as a single line
+// of JSON to w.
+func writeSimulationJSON(w io.Writer, sim *simulation) error {
+	tree, err := sim.tree()
+	if err != nil {
+		return err
+	}
+	js := jsonSimulation{
+		Version:            schemaVersion,
+		Source:             sim.source.FullPath(),
+		Destination:        sim.destination.FullPath(),
+		Plan:               make([]jsonOperation, 0, len(sim.ops)),
+		RemovedDirectories: append([]string{}, sim.removedDirs...),
+		Tree:               tree,
+		Failures:           []string{},
+	}
+	for _, op := range sim.ops {
+		js.Plan = append(js.Plan, newJSONOperation(op))
+	}
+	for _, err := range failures(sim.err) {
+		js.Failures = append(js.Failures, err.Error())
+	}
+	js.Statistics = jsonStatistics{
+		Files:              len(sim.ops),
+		Renamed:            sim.renamed(),
+		Truncated:          sim.truncated(),
+		RemovedDirectories: len(sim.removedDirs),
+		Failures:           len(js.Failures),
+	}
+	return json.NewEncoder(w).Encode(js)
+}
+
+// failures returns the single errors contained in err.
+func failures(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if multiErr, ok := err.(*flatten.MultiError); ok {
+		return multiErr.Errors
+	}
+	return []error{err}
+}
+
+// jsonEvent is a single line of the NDJSON output.
+type jsonEvent struct {
+	Version     int    `json:"version"`
+	Time        string `json:"time"`
+	Event       string `json:"event"`
+	Action      string `json:"action,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	Directory   string `json:"directory,omitempty"`
+	Total       *int   `json:"total,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// The planned event additionally contains the plan, and if it
+	// comes from the simulation, also the directories to remove and
+	// the predicted failures.
+	Plan               []jsonOperation `json:"plan,omitempty"`
+	RemovedDirectories []string        `json:"removed_directories,omitempty"`
+	Failures           []string        `json:"failures,omitempty"`
+}
+
+// eventStream is a flatten.Observer, which writes every event
+// as a line of JSON to the underlying encoder.
+type eventStream struct {
+	enc *json.Encoder
+	// planned is true once the planned event has been written.
+	planned bool
+	// err is the first error, which occurred while writing an
+	// event. No more events are written afterwards.
+	err error
+}
+
+func newEventStream(w io.Writer) *eventStream {
+	return &eventStream{enc: json.NewEncoder(w)}
+}
+
+func (es *eventStream) emit(ev jsonEvent) {
+	if es.err != nil {
+		return
+	}
+	ev.Version = schemaVersion
+	ev.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	es.err = es.enc.Encode(ev)
+}
+
+// emitPlanned writes the planned event for ops, unless it has been
+// written already.
+func (es *eventStream) emitPlanned(ev jsonEvent, ops []flatten.Operation) {
+	if es.planned {
+		return
+	}
+	es.planned = true
+	total := len(ops)
+	ev.Event, ev.Total, ev.Plan = "planned", &total, make([]jsonOperation, 0, len(ops))
+	for _, op := range ops {
+		ev.Plan = append(ev.Plan, newJSONOperation(op))
+	}
+	es.emit(ev)
+}
+
+// emitSimulation writes the simulation sim as the planned event, so
+// that all the lines of the output are events. The real process does
+// not write another planned event afterwards.
+func (es *eventStream) emitSimulation(sim *simulation) error {
+	ev := jsonEvent{RemovedDirectories: sim.removedDirs}
+	for _, err := range failures(sim.err) {
+		ev.Failures = append(ev.Failures, err.Error())
+	}
+	es.emitPlanned(ev, sim.ops)
+	return es.err
+}
+
+func (es *eventStream) emitOp(event string, op flatten.Operation, reason string, err error) {
+	jo := newJSONOperation(op)
+	ev := jsonEvent{Event: event, Action: jo.Action, Source: jo.Source, Destination: jo.Destination, Reason: reason}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	es.emit(ev)
+}
+
+func (es *eventStream) OnPlanned(ops []flatten.Operation) {
+	es.emitPlanned(jsonEvent{}, ops)
+}
+
+func (es *eventStream) OnFileStart(op flatten.Operation) {
+	es.emitOp("file_start", op, "", nil)
+}
+
+func (es *eventStream) OnFileDone(op flatten.Operation) {
+	es.emitOp("file_done", op, "", nil)
+}
+
+func (es *eventStream) OnSkip(op flatten.Operation, reason string) {
+	es.emitOp("skip", op, reason, nil)
+}
+
+func (es *eventStream) OnError(op flatten.Operation, err error) {
+	es.emitOp("error", op, "", err)
+}
+
+func (es *eventStream) OnDirRemoved(dir string) {
+	es.emit(jsonEvent{Event: "dir_removed", Directory: dir})
+}
+
+func (es *eventStream) OnFinish(err error) {
+	ev := jsonEvent{Event: "finish"}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	es.emit(ev)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goggle/flatten/flatten"
+)
+
+func TestWriteSimulationJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       flatten.Options
+		err        error
+		plan       []jsonOperation
+		removed    []string
+		statistics jsonStatistics
+		failures   []string
+	}{
+		{
+			name: "move",
+			plan: []jsonOperation{
+				{"move", "/src/a/x.txt", "/dst/x_1.txt", false},
+				{"move", "/src/b/x.txt", "/dst/x_2.txt", false},
+				{"move", "/src/d/averyveryverylongname.txt", "/dst/averyveryverylongname.txt", false},
+			},
+			removed:    []string{"/src/d", "/src/b", "/src/a"},
+			statistics: jsonStatistics{Files: 3, Renamed: 2, RemovedDirectories: 3},
+			failures:   []string{},
+		},
+		{
+			name: "copy",
+			opts: flatten.Options{CopyOnly: true, MaxNameBytes: 20},
+			plan: []jsonOperation{
+				{"copy", "/src/a/x.txt", "/dst/x_1.txt", false},
+				{"copy", "/src/b/x.txt", "/dst/x_2.txt", false},
+				{"copy", "/src/d/averyveryverylongname.txt", "/dst/averyveryverylon.txt", true},
+			},
+			removed:    []string{},
+			statistics: jsonStatistics{Files: 3, Renamed: 3, Truncated: 1},
+			failures:   []string{},
+		},
+		{
+			name: "failures",
+			opts: flatten.Options{CopyOnly: true},
+			err:  &flatten.MultiError{Errors: []error{errors.New("disk full"), errors.New("permission denied")}},
+			plan: []jsonOperation{
+				{"copy", "/src/a/x.txt", "/dst/x_1.txt", false},
+				{"copy", "/src/b/x.txt", "/dst/x_2.txt", false},
+				{"copy", "/src/d/averyveryverylongname.txt", "/dst/averyveryverylongname.txt", false},
+			},
+			removed:    []string{},
+			statistics: jsonStatistics{Files: 3, Renamed: 2, Failures: 2},
+			failures:   []string{"disk full", "permission denied"},
+		},
+	}
+	for _, test := range tests {
+		sim := simulateListing(t, test.opts, "/src/a/x.txt", "/src/b/x.txt", "/src/d/averyveryverylongname.txt", "/dst/keep")
+		if test.err != nil {
+			sim.err = test.err
+		}
+		var buf bytes.Buffer
+		err := writeSimulationJSON(&buf, sim)
+		if err != nil {
+			t.Fatalf("%v: no error expected, got %v", test.name, err)
+		}
+		if strings.Count(buf.String(), "\n") != 1 {
+			t.Errorf("%v: expected a single line, got %q", test.name, buf.String())
+		}
+		var js struct {
+			jsonSimulation
+			Tree json.RawMessage `json:"tree"`
+		}
+		err = json.Unmarshal(buf.Bytes(), &js)
+		if err != nil {
+			t.Fatalf("%v: invalid JSON: %v", test.name, err)
+		}
+		if js.Version != schemaVersion || js.Source != "/src" || js.Destination != "/dst" {
+			t.Errorf("%v: expected version %v from /src to /dst, got %v from %v to %v", test.name, schemaVersion, js.Version, js.Source, js.Destination)
+		}
+		if !reflect.DeepEqual(js.Plan, test.plan) {
+			t.Errorf("%v: expected the plan %+v, got %+v", test.name, test.plan, js.Plan)
+		}
+		if !reflect.DeepEqual(js.RemovedDirectories, test.removed) {
+			t.Errorf("%v: expected the removed directories %q, got %q", test.name, test.removed, js.RemovedDirectories)
+		}
+		if js.Statistics != test.statistics {
+			t.Errorf("%v: expected the statistics %+v, got %+v", test.name, test.statistics, js.Statistics)
+		}
+		if !reflect.DeepEqual(js.Failures, test.failures) {
+			t.Errorf("%v: expected the failures %q, got %q", test.name, test.failures, js.Failures)
+		}
+		if !strings.Contains(string(js.Tree), `"name":"keep"`) {
+			t.Errorf("%v: expected the tree to contain the untouched files, got %s", test.name, js.Tree)
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	op := flatten.Operation{Source: "/src/a/x", Destination: "/dst/x"}
+	total := 2
+	tests := []struct {
+		emit     func(es *eventStream)
+		expected jsonEvent
+	}{
+		{func(es *eventStream) { es.OnPlanned([]flatten.Operation{op, op}) }, jsonEvent{Event: "planned", Total: &total, Plan: []jsonOperation{newJSONOperation(op), newJSONOperation(op)}}},
+		{func(es *eventStream) { es.OnFileStart(op) }, jsonEvent{Event: "file_start", Action: "move", Source: "/src/a/x", Destination: "/dst/x"}},
+		{func(es *eventStream) {
+			es.OnFileDone(flatten.Operation{Source: "/src/a/x", Destination: "/dst/x", Copy: true})
+		}, jsonEvent{Event: "file_done", Action: "copy", Source: "/src/a/x", Destination: "/dst/x"}},
+		{func(es *eventStream) { es.OnSkip(op, "exists") }, jsonEvent{Event: "skip", Action: "move", Source: "/src/a/x", Destination: "/dst/x", Reason: "exists"}},
+		{func(es *eventStream) { es.OnError(op, errors.New("disk full")) }, jsonEvent{Event: "error", Action: "move", Source: "/src/a/x", Destination: "/dst/x", Error: "disk full"}},
+		{func(es *eventStream) { es.OnDirRemoved("/src/a") }, jsonEvent{Event: "dir_removed", Directory: "/src/a"}},
+		{func(es *eventStream) { es.OnFinish(nil) }, jsonEvent{Event: "finish"}},
+		{func(es *eventStream) { es.OnFinish(errors.New("1 failure")) }, jsonEvent{Event: "finish", Error: "1 failure"}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		test.emit(newEventStream(&buf))
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) != 1 {
+			t.Errorf("%v: expected a single line, got %q", test.expected.Event, buf.String())
+			continue
+		}
+		var ev jsonEvent
+		err := json.Unmarshal([]byte(lines[0]), &ev)
+		if err != nil {
+			t.Errorf("%v: invalid JSON: %v", test.expected.Event, err)
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339Nano, ev.Time); err != nil {
+			t.Errorf("%v: invalid time %q", test.expected.Event, ev.Time)
+		}
+		ev.Time = ""
+		test.expected.Version = schemaVersion
+		if !reflect.DeepEqual(ev, test.expected) {
+			t.Errorf("%v: expected %+v, got %+v", test.expected.Event, test.expected, ev)
+		}
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestEventStreamSimulation(t *testing.T) {
+	sim := simulateListing(t, flatten.Options{}, "/src/a/x.txt")
+	sim.err = &flatten.MultiError{Errors: []error{errors.New("disk full")}}
+	var buf bytes.Buffer
+	es := newEventStream(&buf)
+	err := es.emitSimulation(sim)
+	if err != nil {
+		t.Fatalf("no error expected, got %v", err)
+	}
+	// The real process does not repeat the planned event:
+	es.OnPlanned(sim.ops)
+	es.OnFinish(nil)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected the planned and the finish event, got %q", buf.String())
+	}
+	var ev jsonEvent
+	err = json.Unmarshal([]byte(lines[0]), &ev)
+	if err != nil || ev.Event != "planned" || *ev.Total != 1 || ev.Plan[0].Destination != "/dst/x.txt" ||
+		!reflect.DeepEqual(ev.RemovedDirectories, []string{"/src/a"}) || !reflect.DeepEqual(ev.Failures, []string{"disk full"}) {
+		t.Errorf("expected the planned event of the simulation, got %v (%v)", lines[0], err)
+	}
+
+	es = newEventStream(failingWriter{})
+	err = es.emitSimulation(sim)
+	if err == nil || es.err != err {
+		t.Errorf("expected the write error to be recorded, got %v", err)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/goggle/flatten/filesystem"
+	"github.com/goggle/flatten/flatten"
+	"golang.org/x/term"
+)
+
+// Preview modes of the simulation:
+const (
+	previewChanges = "changes"
+	previewTree    = "tree"
+	previewSide    = "side-by-side"
+)
+
+// Values of the --color option:
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// ANSI escape sequences for the change preview:
+const (
+	ansiReset  = "\x1b[0m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiRed    = "\x1b[31m"
+	ansiFaint  = "\x1b[2m"
+)
+
+// useColor decides, if the output to stdout gets colored. In the
+// auto mode, colors are used if stdout is a terminal and the
+// NO_COLOR environment variable is not set.
+func useColor(when string) bool {
+	switch when {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	}
+	return os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// paint wraps s into the ANSI color code, if color is true.
+func paint(s, code string, color bool) string {
+	if !color {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// writeTree writes the whole resulting destination tree of the
+// simulation sim to w, including the removed directories and the
+// origins of the arriving files.
+func writeTree(w io.Writer, sim *simulation, format filesystem.Format, opts filesystem.RenderOptions) error {
+	tree, decorate, err := sim.annotatedTree()
+	if err != nil {
+		return err
+	}
+	opts.Decorate = decorate
+	opts.DirStats = true
+	return tree.Export(w, format, opts)
+}
+
+// maxNameColumn limits the width of the name column in the
+// change preview, so that very long names do not push all the
+// origins out of the screen.
+const maxNameColumn = 40
+
+// plural returns n followed by the singular or the plural
+// form of a word, depending on n.
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return filesystem.FormatCount(n) + " " + singular
+	}
+	return filesystem.FormatCount(n) + " " + plural
+}
+
+// relativeTo returns p relative to the directory base, if p is
+// located in base, otherwise p itself.
+func relativeTo(base, p string) string {
+	rel, err := filepath.Rel(base, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return p
+	}
+	return rel
+}
+
+// summarize returns a single line summary of the changes performed
+// by the simulation sim.
+func summarize(sim *simulation, unchanged int) string {
+	action := "move"
+	if len(sim.ops) > 0 && sim.ops[0].Copy {
+		action = "copy"
+	}
+	summary := plural(len(sim.ops), "file", "files") + " to " + action
+	renamed, truncated := sim.renamed(), sim.truncated()
+	switch {
+	case renamed > 0 && truncated > 0:
+		summary += " (" + filesystem.FormatCount(renamed) + " renamed, " + filesystem.FormatCount(truncated) + " truncated)"
+	case renamed > 0:
+		summary += " (" + filesystem.FormatCount(renamed) + " renamed)"
+	}
+	summary += ", " + plural(len(sim.removedDirs), "directory", "directories") + " to remove"
+	summary += ", " + plural(unchanged, "unchanged entry", "unchanged entries")
+	if len(sim.failed) > 0 {
+		summary += ", " + plural(len(sim.failed), "predicted failure", "predicted failures")
+	}
+	return summary
+}
+
+// writeChanges writes a diff-style preview of the simulation sim to w.
+// Files arriving in the destination are marked with "+" (or "~" if
+// they get renamed because of a collision or a too long name) together
+// with their origin, and truncated names are marked as such. Files,
+// which are predicted to fail, are marked with "!" together with the
+// reason, removed directories are marked with "-", and all the
+// untouched entries are collapsed into a single line.
+func writeChanges(w io.Writer, sim *simulation, color bool) error {
+	unchanged, err := sim.unchanged()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%v\n\n", summarize(sim, unchanged))
+
+	dst := sim.destination.FullPath()
+	src := sim.source.FullPath()
+	fmt.Fprintln(w, dst)
+
+	ops := append(sim.ops[:0:0], sim.ops...)
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Destination < ops[j].Destination
+	})
+	width := 0
+	for _, op := range ops {
+		if l := utf8.RuneCountInString(relativeTo(dst, op.Destination)); l > width {
+			width = l
+		}
+	}
+	if width > maxNameColumn {
+		width = maxNameColumn
+	}
+	for _, op := range ops {
+		line := fmt.Sprintf("+ %-*v", width, truncate(relativeTo(dst, op.Destination), width))
+		code := ansiGreen
+		if filepath.Base(op.Source) != filepath.Base(op.Destination) {
+			line = "~" + line[1:]
+			code = ansiYellow
+		}
+		reason := ""
+		if op.Truncated {
+			reason = "  (truncated)"
+		}
+		if err, ok := sim.failed[op]; ok {
+			line = "!" + line[1:]
+			code = ansiRed
+			reason += "  (" + err.Error() + ")"
+		}
+		fmt.Fprintf(w, "%v  ← %v%v\n", paint(line, code, color), relativeTo(src, op.Source), reason)
+	}
+
+	removed := append([]string{}, sim.removedDirs...)
+	sort.Strings(removed)
+	for _, d := range removed {
+		fmt.Fprintln(w, paint("- "+relativeTo(src, d)+"/", ansiRed, color))
+	}
+	if unchanged > 0 {
+		fmt.Fprintln(w, paint("  … "+plural(unchanged, "unchanged entry", "unchanged entries"), ansiFaint, color))
+	}
+	return nil
+}
+
+// defaultWidth is the width of the side-by-side preview, if the
+// width of the terminal cannot be determined.
+const defaultWidth = 80
+
+// terminalWidth returns the width of the terminal attached to stdout.
+// Otherwise the COLUMNS environment variable or defaultWidth is used.
+func terminalWidth() int {
+	if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 {
+		return width
+	}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return defaultWidth
+}
+
+// truncate shortens s to at most width characters, replacing the
+// end of a truncated string by an ellipsis.
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	if width <= 0 {
+		return ""
+	}
+	return string(r[:width-1]) + "…"
+}
+
+// sideBySideRow is a single line of the side-by-side preview.
+type sideBySideRow struct {
+	// leftPrefix is the indentation and the connector of the entry
+	// on the left side.
+	leftPrefix, left string
+	// connector connects the entry on the right side with the
+	// destination directory.
+	connector string
+	right     string
+	// linked marks the rows, in which the right side shows the new
+	// location of the file on the left side.
+	linked bool
+	// leftCode and rightCode are the ANSI colors of both sides.
+	leftCode, rightCode string
+}
+
+// sideBySideArrow separates both sides of linked rows.
+const sideBySideArrow = " → "
+
+// writeSideBySide writes the source tree before the simulation sim on
+// the left and the resulting destination on the right side to w. Every
+// file arriving in the destination is shown in the same line as its
+// origin, so that both locations are linked by an arrow, also if it is
+// predicted to fail. The untouched entries of the destination are
+// collapsed into a single line. Both trees are fitted into width
+// characters below the summary.
+func writeSideBySide(w io.Writer, sim *simulation, opts filesystem.RenderOptions, width int) error {
+	unchanged, err := sim.unchanged()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%v\n\n", summarize(sim, unchanged))
+
+	color := opts.Color
+	opts.Color = false
+	opts.Decorate = nil
+	opts.DirStats = false
+	arrivals := map[string]flatten.Operation{}
+	for _, op := range sim.ops {
+		arrivals[op.Source] = op
+	}
+	removed := map[string]bool{}
+	for _, d := range sim.removedDirs {
+		removed[d] = true
+	}
+
+	// The left side is the source tree, the right side starts with
+	// the destination directory and continues with the arriving files
+	// in the order of their origins:
+	rows := []sideBySideRow{}
+	shown := 0
+	sourceTree, err := sim.sourceTree()
+	if err != nil {
+		return err
+	}
+	for _, line := range sourceTree.Lines(opts) {
+		row := sideBySideRow{leftPrefix: line.Prefix, left: line.Label}
+		if line.Tree != nil {
+			p := line.Tree.Node().FullPath()
+			if removed[p] {
+				row.leftCode = ansiRed
+			}
+			if op, ok := arrivals[p]; ok && (sim.fs.Exists(op.Destination) || sim.failed[op] != nil) {
+				row.right = filepath.Base(op.Destination)
+				row.linked = true
+				row.rightCode = ansiGreen
+				if filepath.Base(p) != row.right {
+					row.rightCode = ansiYellow
+				}
+				if op.Truncated {
+					row.right += " (truncated)"
+				}
+				if sim.failed[op] != nil {
+					row.right += " (fails)"
+					row.rightCode = ansiRed
+				}
+				shown++
+			}
+		}
+		rows = append(rows, row)
+	}
+	rows[0].right = sim.destination.FullPath()
+
+	// Arriving files, whose origins are hidden because of the render
+	// options, and the untouched entries are collapsed:
+	if hidden := len(sim.ops) - shown; hidden > 0 {
+		rows = append(rows, sideBySideRow{right: "… " + plural(hidden, "more arriving file", "more arriving files")})
+	}
+	if unchanged > 0 {
+		rows = append(rows, sideBySideRow{right: "… " + plural(unchanged, "unchanged entry", "unchanged entries"), rightCode: ansiFaint})
+	}
+
+	// Connect the entries on the right side like in a tree:
+	last := 0
+	for i := range rows {
+		if rows[i].right != "" {
+			last = i
+		}
+	}
+	for i := 1; i <= last; i++ {
+		switch {
+		case i == last:
+			rows[i].connector = "└── "
+		case rows[i].right != "":
+			rows[i].connector = "├── "
+		default:
+			rows[i].connector = "│"
+		}
+	}
+
+	// Fit both sides into the available width. The left side gets
+	// at least half of it, if both sides do not fit:
+	leftWidth, rightWidth := 0, 0
+	for _, row := range rows {
+		if l := utf8.RuneCountInString(row.leftPrefix + row.left); l > leftWidth {
+			leftWidth = l
+		}
+		if l := utf8.RuneCountInString(row.connector + row.right); l > rightWidth {
+			rightWidth = l
+		}
+	}
+	available := width - utf8.RuneCountInString(sideBySideArrow)
+	if leftWidth+rightWidth > available {
+		leftWidth = available - rightWidth
+		if leftWidth < available/2 {
+			leftWidth = available / 2
+		}
+	}
+	rightWidth = available - leftWidth
+	if leftWidth < 1 || rightWidth < 1 {
+		return fmt.Errorf("the terminal is too narrow for the %v preview", previewSide)
+	}
+
+	for _, row := range rows {
+		prefix := truncate(row.leftPrefix, leftWidth)
+		left := truncate(row.left, leftWidth-utf8.RuneCountInString(prefix))
+		padding := strings.Repeat(" ", leftWidth-utf8.RuneCountInString(prefix+left))
+		connector := truncate(row.connector, rightWidth)
+		right := truncate(row.right, rightWidth-utf8.RuneCountInString(connector))
+		if row.leftCode != "" {
+			left = paint(left, row.leftCode, color)
+		}
+		if connector == "" && right == "" {
+			fmt.Fprintln(w, prefix+left)
+			continue
+		}
+		separator := "   "
+		if row.linked {
+			separator = paint(sideBySideArrow, ansiFaint, color)
+		}
+		if row.rightCode != "" {
+			right = paint(right, row.rightCode, color)
+		}
+		fmt.Fprintln(w, prefix+left+padding+separator+connector+right)
+	}
+	return nil
+}
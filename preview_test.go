@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/goggle/flatten/filesystem"
+	"github.com/goggle/flatten/flatten"
+	"golang.org/x/term"
+)
+
+func TestFormatCount(t *testing.T) {
+	tests := []struct {
+		n       int
+		count   string
+		entries string
+	}{
+		{0, "0", "0 entries"},
+		{1, "1", "1 entry"},
+		{999, "999", "999 entries"},
+		{1000, "1,000", "1,000 entries"},
+		{123456, "123,456", "123,456 entries"},
+		{1234567, "1,234,567", "1,234,567 entries"},
+	}
+	for _, test := range tests {
+		if result := filesystem.FormatCount(test.n); result != test.count {
+			t.Errorf("FormatCount(%v): expected %q, got %q", test.n, test.count, result)
+		}
+		if result := plural(test.n, "entry", "entries"); result != test.entries {
+			t.Errorf("plural(%v): expected %q, got %q", test.n, test.entries, result)
+		}
+	}
+}
+
+// previewFiles are the files of the simulations, whose previews are
+// tested.
+var previewFiles = []string{"/src/a/x.txt", "/src/b/x.txt", "/src/b/c/y.txt", "/src/d/averyveryverylongname.txt", "/dst/keep"}
+
+func TestWriteChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     flatten.Options
+		color    bool
+		expected string
+	}{
+		{"move", flatten.Options{}, false, `4 files to move (2 renamed), 4 directories to remove, 1 unchanged entry
+
+/dst
++ averyveryverylongname.txt  ← d/averyveryverylongname.txt
+~ x_1.txt                    ← a/x.txt
+~ x_2.txt                    ← b/x.txt
++ y.txt                      ← b/c/y.txt
+- a/
+- b/
+- b/c/
+- d/
+  … 1 unchanged entry
+`},
+		{"truncated", flatten.Options{MaxNameBytes: 20}, false, `4 files to move (3 renamed, 1 truncated), 4 directories to remove, 1 unchanged entry
+
+/dst
+~ averyveryverylon.txt  ← d/averyveryverylongname.txt  (truncated)
+~ x_1.txt               ← a/x.txt
+~ x_2.txt               ← b/x.txt
++ y.txt                 ← b/c/y.txt
+- a/
+- b/
+- b/c/
+- d/
+  … 1 unchanged entry
+`},
+		{"copy", flatten.Options{CopyOnly: true, MaxNameBytes: 20}, true, "4 files to copy (3 renamed, 1 truncated), 0 directories to remove, 1 unchanged entry\n\n/dst\n" +
+			"\x1b[33m~ averyveryverylon.txt\x1b[0m  ← d/averyveryverylongname.txt  (truncated)\n" +
+			"\x1b[33m~ x_1.txt             \x1b[0m  ← a/x.txt\n" +
+			"\x1b[33m~ x_2.txt             \x1b[0m  ← b/x.txt\n" +
+			"\x1b[32m+ y.txt               \x1b[0m  ← b/c/y.txt\n" +
+			"\x1b[2m  … 1 unchanged entry\x1b[0m\n"},
+		{"colored", flatten.Options{}, true, "4 files to move (2 renamed), 4 directories to remove, 1 unchanged entry\n\n/dst\n" +
+			"\x1b[32m+ averyveryverylongname.txt\x1b[0m  ← d/averyveryverylongname.txt\n" +
+			"\x1b[33m~ x_1.txt                  \x1b[0m  ← a/x.txt\n" +
+			"\x1b[33m~ x_2.txt                  \x1b[0m  ← b/x.txt\n" +
+			"\x1b[32m+ y.txt                    \x1b[0m  ← b/c/y.txt\n" +
+			"\x1b[31m- a/\x1b[0m\n\x1b[31m- b/\x1b[0m\n\x1b[31m- b/c/\x1b[0m\n\x1b[31m- d/\x1b[0m\n" +
+			"\x1b[2m  … 1 unchanged entry\x1b[0m\n"},
+	}
+	for _, test := range tests {
+		sim := simulateListing(t, test.opts, previewFiles...)
+		var buf bytes.Buffer
+		err := writeChanges(&buf, sim, test.color)
+		if err != nil {
+			t.Fatalf("%v: no error expected, got %v", test.name, err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("%v: expected\n%v\ngot\n%v", test.name, test.expected, buf.String())
+		}
+	}
+
+	// Failures are marked with the reason:
+	sim := simulateListing(t, flatten.Options{}, "/src/a/x.txt")
+	sim.failed[sim.ops[0]] = filesystem.ErrNoSpace
+	var buf bytes.Buffer
+	err := writeChanges(&buf, sim, false)
+	expected := "1 file to move, 1 directory to remove, 0 unchanged entries, 1 predicted failure\n\n/dst\n! x.txt  ← a/x.txt  (no space left on device)\n- a/\n"
+	if err != nil || buf.String() != expected {
+		t.Errorf("failure: expected\n%v\ngot\n%v (%v)", expected, buf.String(), err)
+	}
+
+	// The name column is measured in characters and limited to
+	// maxNameColumn:
+	sim = simulateListing(t, flatten.Options{}, "/src/ä/ärger.txt", "/src/e/"+strings.Repeat("n", 50)+".txt")
+	buf.Reset()
+	err = writeChanges(&buf, sim, false)
+	expected = "2 files to move, 2 directories to remove, 0 unchanged entries\n\n/dst\n" +
+		"+ " + strings.Repeat("n", 39) + "…  ← e/" + strings.Repeat("n", 50) + ".txt\n" +
+		"+ ärger.txt" + strings.Repeat(" ", 31) + "  ← ä/ärger.txt\n- e/\n- ä/\n"
+	if err != nil || buf.String() != expected {
+		t.Errorf("width: expected\n%v\ngot\n%v (%v)", expected, buf.String(), err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s        string
+		width    int
+		expected string
+	}{
+		{"report.pdf", 20, "report.pdf"},
+		{"report.pdf", 10, "report.pdf"},
+		{"report.pdf", 9, "report.p…"},
+		{"äöü.txt", 4, "äöü…"},
+		{"report.pdf", 1, "…"},
+		{"report.pdf", 0, ""},
+		{"report.pdf", -3, ""},
+		{"", 0, ""},
+	}
+	for _, test := range tests {
+		if result := truncate(test.s, test.width); result != test.expected {
+			t.Errorf("truncate(%q, %v): expected %q, got %q", test.s, test.width, test.expected, result)
+		}
+	}
+}
+
+func TestTerminalWidth(t *testing.T) {
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		t.Skip("stdout is a terminal")
+	}
+	tests := []struct {
+		columns  string
+		expected int
+	}{
+		{"120", 120},
+		{"", defaultWidth},
+		{"0", defaultWidth},
+		{"wide", defaultWidth},
+	}
+	for _, test := range tests {
+		t.Setenv("COLUMNS", test.columns)
+		if width := terminalWidth(); width != test.expected {
+			t.Errorf("terminalWidth(COLUMNS=%q): expected %v, got %v", test.columns, test.expected, width)
+		}
+	}
+}
+
+func TestWriteSideBySide(t *testing.T) {
+	summary := "4 files to move (3 renamed, 1 truncated), 4 directories to remove, 1 unchanged entry\n\n"
+	tests := []struct {
+		name     string
+		width    int
+		color    bool
+		expected string
+	}{
+		{"wide", 80, false, summary + `/src                                /dst
+├── a                               │
+│   └── x.txt                     → ├── x_1.txt
+├── b                               │
+│   ├── c                           │
+│   │   └── y.txt                 → ├── y.txt
+│   └── x.txt                     → ├── x_2.txt
+└── d                               │
+    └── averyveryverylongname.txt → ├── averyveryverylon.txt (truncated)
+                                    └── … 1 unchanged entry
+`},
+		{"narrow", 40, false, summary + `/src                 /dst
+├── a                │
+│   └── x.txt      → ├── x_1.txt
+├── b                │
+│   ├── c            │
+│   │   └── y.txt  → ├── y.txt
+│   └── x.txt      → ├── x_2.txt
+└── d                │
+    └── averyvery… → ├── averyveryveryl…
+                     └── … 1 unchanged …
+`},
+		{"colored", 80, true, summary + "/src                                /dst\n" +
+			"├── \x1b[31ma\x1b[0m                               │\n" +
+			"│   └── x.txt                    \x1b[2m → \x1b[0m├── \x1b[33mx_1.txt\x1b[0m\n" +
+			"├── \x1b[31mb\x1b[0m                               │\n" +
+			"│   ├── \x1b[31mc\x1b[0m                           │\n" +
+			"│   │   └── y.txt                \x1b[2m → \x1b[0m├── \x1b[32my.txt\x1b[0m\n" +
+			"│   └── x.txt                    \x1b[2m → \x1b[0m├── \x1b[33mx_2.txt\x1b[0m\n" +
+			"└── \x1b[31md\x1b[0m                               │\n" +
+			"    └── averyveryverylongname.txt\x1b[2m → \x1b[0m├── \x1b[33maveryveryverylon.txt (truncated)\x1b[0m\n" +
+			"                                    └── \x1b[2m… 1 unchanged entry\x1b[0m\n"},
+	}
+	for _, test := range tests {
+		sim := simulateListing(t, flatten.Options{MaxNameBytes: 20}, previewFiles...)
+		var buf bytes.Buffer
+		err := writeSideBySide(&buf, sim, filesystem.RenderOptions{Color: test.color}, test.width)
+		if err != nil {
+			t.Fatalf("%v: no error expected, got %v", test.name, err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("%v: expected\n%v\ngot\n%v", test.name, test.expected, buf.String())
+		}
+		if !test.color {
+			// Only the summary is not fitted into the width:
+			trees := strings.TrimPrefix(buf.String(), summary)
+			for _, line := range strings.Split(strings.TrimSuffix(trees, "\n"), "\n") {
+				if utf8.RuneCountInString(line) > test.width {
+					t.Errorf("%v: expected at most %v characters, got %q", test.name, test.width, line)
+				}
+			}
+		}
+	}
+
+	sim := simulateListing(t, flatten.Options{}, previewFiles...)
+	err := writeSideBySide(io.Discard, sim, filesystem.RenderOptions{}, 4)
+	if err == nil {
+		t.Errorf("too narrow: expected an error")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/goggle/flatten/flatten"
+)
+
+// progressBarWidth is the number of characters used for the bar itself.
+const progressBarWidth = 40
+
+// progressBar is a flatten.Observer, which draws a progress bar
+// to w while the files are being copied or moved. Failed operations
+// count as processed and are shown separately.
+type progressBar struct {
+	flatten.BaseObserver
+	w      io.Writer
+	total  int
+	done   int
+	failed int
+}
+
+func (pb *progressBar) OnPlanned(ops []flatten.Operation) {
+	pb.total = len(ops)
+	pb.done = 0
+	pb.failed = 0
+	pb.draw()
+}
+
+func (pb *progressBar) OnFileDone(op flatten.Operation) {
+	pb.done++
+	pb.draw()
+}
+
+func (pb *progressBar) OnSkip(op flatten.Operation, reason string) {
+	pb.done++
+	pb.draw()
+}
+
+func (pb *progressBar) OnError(op flatten.Operation, err error) {
+	pb.done++
+	pb.failed++
+	pb.draw()
+}
+
+func (pb *progressBar) OnFinish(err error) {
+	if pb.total > 0 {
+		fmt.Fprintln(pb.w)
+	}
+}
+
+func (pb *progressBar) draw() {
+	if pb.total == 0 {
+		return
+	}
+	filled := pb.done * progressBarWidth / pb.total
+	bar := strings.Repeat("#", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	fmt.Fprintf(pb.w, "\r[%v] %v/%v", bar, pb.done, pb.total)
+	if pb.failed > 0 {
+		fmt.Fprintf(pb.w, " (%v failed)", pb.failed)
+	}
+}
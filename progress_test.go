@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/goggle/flatten/flatten"
+)
+
+func TestProgressBar(t *testing.T) {
+	ops := []flatten.Operation{
+		{Source: "/src/a/x", Destination: "/dst/x"},
+		{Source: "/src/b/y", Destination: "/dst/y"},
+		{Source: "/src/c/z", Destination: "/dst/z"},
+		{Source: "/src/d/w", Destination: "/dst/w"},
+	}
+	var buf bytes.Buffer
+	pb := &progressBar{w: &buf}
+	pb.OnPlanned(ops)
+	pb.OnFileDone(ops[0])
+	pb.OnError(ops[1], errors.New("permission denied"))
+	pb.OnSkip(ops[2], "source and destination are identical")
+	pb.OnError(ops[3], errors.New("no space left on device"))
+	pb.OnFinish(nil)
+
+	frames := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\r")
+	last := frames[len(frames)-1]
+	expected := "[" + strings.Repeat("#", progressBarWidth) + "] 4/4 (2 failed)"
+	if last != expected {
+		t.Errorf("expected the last frame %q, got %q", expected, last)
+	}
+	if !strings.Contains(buf.String(), "2/4 (1 failed)") {
+		t.Errorf("expected the failures to be counted as processed, got %q", buf.String())
+	}
+	if !strings.HasSuffix(buf.String(), "\n") {
+		t.Errorf("expected a newline after the progress bar, got %q", buf.String())
+	}
+}
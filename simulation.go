@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/goggle/flatten/filesystem"
+	"github.com/goggle/flatten/flatten"
+	"github.com/goggle/flatten/osabstraction"
+)
+
+// listingAuto is the format of a listing, which is guessed from the
+// name and the content of the file.
+const listingAuto = "auto"
+
+// simulation is the result of a flattening process, which has been
+// performed on a simulated filesystem.
+type simulation struct {
+	source      osabstraction.FileInfo
+	destination osabstraction.FileInfo
+	// fs is the simulated filesystem after the process.
+	fs *filesystem.Overlay
+	// before is the filesystem before the process.
+	before osabstraction.OSWrapper
+	// ops are the planned operations.
+	ops []flatten.Operation
+	// removedDirs are the directories, which have been removed.
+	removedDirs []string
+	// failed maps the operations, which are predicted to fail, to
+	// their errors.
+	failed map[flatten.Operation]error
+	// err contains the failures, which are predicted for the single
+	// files and directories.
+	err error
+}
+
+// nothingToDo returns true if the process neither copies or moves any
+// files nor removes any directories.
+func (sim *simulation) nothingToDo() bool {
+	return len(sim.ops) == 0 && len(sim.removedDirs) == 0
+}
+
+// renamed returns the number of files, which get a new name
+// because of a filename collision.
+func (sim *simulation) renamed() int {
+	count := 0
+	for _, op := range sim.ops {
+		if filepath.Base(op.Source) != filepath.Base(op.Destination) {
+			count++
+		}
+	}
+	return count
+}
+
+// truncated returns the number of files, whose names get shortened,
+// since they are too long for the destination.
+func (sim *simulation) truncated() int {
+	count := 0
+	for _, op := range sim.ops {
+		if op.Truncated {
+			count++
+		}
+	}
+	return count
+}
+
+// unchanged returns the number of entries located directly in the
+// destination after the process, which have not been touched by it.
+// Only the destination itself is listed, not its whole subtree.
+func (sim *simulation) unchanged() (int, error) {
+	entries, err := osabstraction.List(sim.fs, sim.destination.FullPath())
+	if err != nil {
+		return 0, err
+	}
+	touched := map[string]bool{}
+	for _, op := range sim.ops {
+		touched[op.Destination] = true
+	}
+	count := 0
+	for _, fi := range entries {
+		if !touched[fi.FullPath()] {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// simulatedFile returns the file fi as it is known to the filesystem w,
+// so that its type and its metadata are the simulated ones, even if it
+// does not exist on the real filesystem.
+func simulatedFile(w osabstraction.OSWrapper, fi osabstraction.FileInfo) osabstraction.FileInfo {
+	if s, ok := w.(filesystem.Simulated); ok {
+		if df, exists := s.Lookup(fi.FullPath()); exists {
+			return df
+		}
+	}
+	return fi
+}
+
+// sourceTree returns the source tree before the process.
+func (sim *simulation) sourceTree() (filesystem.Tree, error) {
+	tree := filesystem.Tree{}
+	err := tree.Create(simulatedFile(sim.before, sim.source), sim.before)
+	return tree, err
+}
+
+// tree returns the resulting destination tree.
+func (sim *simulation) tree() (filesystem.Tree, error) {
+	tree := filesystem.Tree{}
+	err := tree.Create(simulatedFile(sim.fs, sim.destination), sim.fs)
+	return tree, err
+}
+
+// annotatedTree returns the resulting destination tree, which also
+// contains the removed directories, together with a function which
+// decorates the elements of the tree according to their status.
+func (sim *simulation) annotatedTree() (filesystem.Tree, func(osabstraction.FileInfo) filesystem.Decoration, error) {
+	tree, err := sim.tree()
+	if err != nil {
+		return tree, nil, err
+	}
+	// Insert the removed directories in the destination, the parents
+	// before their children:
+	removed := map[string]bool{}
+	dirs := append([]string{}, sim.removedDirs...)
+	sort.Strings(dirs)
+	for _, d := range dirs {
+		removed[d] = true
+		if tree.Find(d) != nil || tree.Find(filepath.Dir(d)) == nil {
+			continue
+		}
+		err = tree.InsertSuccessor(filesystem.DummyFile{Path: d, IsDirectory: true})
+		if err != nil {
+			return tree, nil, err
+		}
+	}
+	tree.Sort()
+	arrived := map[string]flatten.Operation{}
+	for _, op := range sim.ops {
+		arrived[op.Destination] = op
+	}
+
+	src := sim.source.FullPath()
+	decorate := func(fi osabstraction.FileInfo) filesystem.Decoration {
+		if removed[fi.FullPath()] {
+			return filesystem.Decoration{Status: filesystem.StatusRemoved, Annotation: "(removed)"}
+		}
+		op, ok := arrived[fi.FullPath()]
+		if !ok {
+			return filesystem.Decoration{Status: filesystem.StatusUnchanged}
+		}
+		dec := filesystem.Decoration{Status: filesystem.StatusNew, Annotation: "← " + relativeTo(src, op.Source)}
+		if filepath.Base(op.Source) != filepath.Base(op.Destination) {
+			dec.Status = filesystem.StatusRenamed
+			dec.Annotation += " (renamed)"
+		}
+		if op.Truncated {
+			dec.Annotation += " (truncated)"
+		}
+		return dec
+	}
+	return tree, decorate, nil
+}
+
+// recorder is a flatten.Observer, which records the planned
+// operations (without the skipped ones), their failures and the
+// removed directories of a simulation.
+type recorder struct {
+	flatten.BaseObserver
+	sim *simulation
+}
+
+func (r recorder) OnPlanned(ops []flatten.Operation) {
+	r.sim.ops = ops
+}
+
+func (r recorder) OnSkip(op flatten.Operation, reason string) {
+	// The planned operations are still being performed, so they
+	// must not be changed in place:
+	ops := make([]flatten.Operation, 0, len(r.sim.ops))
+	for _, o := range r.sim.ops {
+		if o != op {
+			ops = append(ops, o)
+		}
+	}
+	r.sim.ops = ops
+}
+
+func (r recorder) OnError(op flatten.Operation, err error) {
+	r.sim.failed[op] = err
+}
+
+func (r recorder) OnDirRemoved(dir string) {
+	r.sim.removedDirs = append(r.sim.removedDirs, dir)
+}
+
+// validListingFormat returns true if format is the auto mode or one
+// of the formats supported by filesystem.ReadListing.
+func validListingFormat(format string) bool {
+	if format == listingAuto {
+		return true
+	}
+	for _, f := range filesystem.ListingFormats {
+		if string(f) == format {
+			return true
+		}
+	}
+	return false
+}
+
+// readListing reads the listing of files in the file name (see
+// --simulate-from). The format is guessed, if it is "auto". The
+// entries dirs are directories, even if the listing does not tell.
+func readListing(name string, format string, dirs ...string) (filesystem.Filesystem, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if format == listingAuto {
+		format = string(filesystem.DetectListingFormat(name, data))
+	}
+	return filesystem.ReadListing(bytes.NewReader(data), filesystem.Format(format), dirs...)
+}
+
+// simulate performs the flattening process on a simulated filesystem,
+// which reads through to the real one and models its permissions and
+// its free space. If listing is not nil, the simulation reads through
+// to the listed files instead, whose permissions and free space are
+// unknown. The messages of the verbose mode are written to out.
+// The simulation always continues after a failure (see --keep-going),
+// so that all the predicted failures are recorded in the err field of
+// the returned simulation.
+func simulate(sourceFI osabstraction.FileInfo, destinationFI osabstraction.FileInfo, opts flatten.Options, listing filesystem.Filesystem, out io.Writer) (*simulation, error) {
+	sim := &simulation{source: sourceFI, destination: destinationFI, failed: map[flatten.Operation]error{}}
+	var osWrapper osabstraction.OSWrapper
+	if listing != nil {
+		sim.fs = filesystem.NewFSOverlay(listing, "/")
+		sim.before = listing
+		osWrapper = sim.fs
+	} else {
+		sim.fs = filesystem.NewOverlay()
+		sim.before = osabstraction.RealOS{}
+		constrained, err := filesystem.NewConstrained(sim.fs, destinationFI.FullPath())
+		if err != nil {
+			return nil, err
+		}
+		osWrapper = constrained
+	}
+	opts.KeepGoing = true
+	flattener := flatten.New(osWrapper, opts)
+	flattener.SetOutput(out)
+	flattener.AddObserver(recorder{sim: sim})
+	flattenErr := flattener.Flatten(sourceFI, destinationFI)
+	var multiErr *flatten.MultiError
+	if flattenErr != nil && !errors.As(flattenErr, &multiErr) {
+		return nil, flattenErr
+	}
+	sim.err = flattenErr
+	return sim, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/goggle/flatten/filesystem"
+	"github.com/goggle/flatten/flatten"
+	"github.com/goggle/flatten/osabstraction"
+)
+
+// newListing returns a simulated filesystem with the files and the
+// directories (ending with a "/") paths.
+func newListing(t *testing.T, paths ...string) filesystem.Filesystem {
+	t.Helper()
+	fs := filesystem.Filesystem{}
+	fs.Init()
+	for _, p := range paths {
+		var err error
+		if p[len(p)-1] == '/' {
+			err = fs.MkDir(p)
+		} else {
+			err = fs.CreateFile(p)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fs
+}
+
+// simulateListing simulates flattening /src into /dst, which are
+// simulated together with the files and the directories paths (see
+// newListing).
+func simulateListing(t *testing.T, opts flatten.Options, paths ...string) *simulation {
+	t.Helper()
+	listing := newListing(t, append([]string{"/src/", "/dst/"}, paths...)...)
+	sim, err := simulate(osabstraction.File("/src"), osabstraction.File("/dst"), opts, listing, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim
+}
+
+func TestUnchanged(t *testing.T) {
+	tests := []struct {
+		name      string
+		listing   []string
+		src, dst  string
+		unchanged int
+	}{
+		{"separate", []string{"/src/a/x", "/src/b/y", "/dst/keep", "/dst/sub/deep/z"}, "/src", "/dst", 2},
+		{"in place", []string{"/data/a/x", "/data/b/c/y", "/data/keep"}, "/data", "/data", 1},
+		{"collision", []string{"/src/a/x", "/src/b/x", "/dst/x"}, "/src", "/dst", 1},
+	}
+	for _, test := range tests {
+		listing := newListing(t, test.listing...)
+		sim, err := simulate(osabstraction.File(test.src), osabstraction.File(test.dst), flatten.Options{}, listing, io.Discard)
+		if err != nil {
+			t.Fatalf("%v: no error expected, got %v", test.name, err)
+		}
+		unchanged, err := sim.unchanged()
+		if err != nil || unchanged != test.unchanged {
+			t.Errorf("%v: expected %v unchanged entries, got %v (%v)", test.name, test.unchanged, unchanged, err)
+		}
+	}
+
+	// Operations, which share their destination or do not end up in
+	// the destination, must not make the count negative:
+	listing := newListing(t, "/src/a/x", "/dst/")
+	sim, err := simulate(osabstraction.File("/src"), osabstraction.File("/dst"), flatten.Options{}, listing, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.ops = append(sim.ops, sim.ops[0], flatten.Operation{Source: "/src/a/x", Destination: "/elsewhere/x"})
+	if unchanged, err := sim.unchanged(); err != nil || unchanged != 0 {
+		t.Errorf("expected 0 unchanged entries, got %v (%v)", unchanged, err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	docopt "github.com/docopt/docopt-go"
+	"github.com/goggle/flatten/filesystem"
+	"github.com/goggle/flatten/osabstraction"
+)
+
+// renderOptions returns the tree rendering options given on the
+// command line. The program exits if any of them is invalid.
+func renderOptions(arguments docopt.Opts) filesystem.RenderOptions {
+	opts := filesystem.RenderOptions{}
+	var err error
+	opts.MaxDepth, err = strconv.Atoi(arguments["--max-depth"].(string))
+	if err != nil || opts.MaxDepth < 0 {
+		exit(exitInvalidArguments, "Invalid maximal depth: "+arguments["--max-depth"].(string))
+	}
+	opts.MaxChildren, err = strconv.Atoi(arguments["--max-children"].(string))
+	if err != nil || opts.MaxChildren < 0 {
+		exit(exitInvalidArguments, "Invalid maximal number of children: "+arguments["--max-children"].(string))
+	}
+	if pattern, ok := arguments["--pattern"].(string); ok {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			exit(exitInvalidArguments, "Invalid pattern: "+pattern)
+		}
+		opts.Pattern = pattern
+	}
+	opts.DirsOnly = arguments["--dirs-only"].(bool)
+	return opts
+}
+
+// treeFormat returns the tree format given on the command line.
+// The program exits if the format is not supported.
+func treeFormat(arguments docopt.Opts) filesystem.Format {
+	format := filesystem.Format(arguments["--tree-format"].(string))
+	for _, f := range filesystem.Formats {
+		if f == format {
+			return format
+		}
+	}
+	exit(exitInvalidArguments, "Invalid tree format: "+string(format))
+	return format
+}
+
+// runTree prints the directory tree of the PATH argument, which is
+// the tree command of the program.
+func runTree(arguments docopt.Opts, format filesystem.Format, opts filesystem.RenderOptions) {
+	p, ok := arguments["PATH"].(string)
+	if !ok {
+		wd, err := os.Getwd()
+		if err != nil {
+			exit(exitFailure, err)
+		}
+		p = wd
+	}
+	root := osabstraction.File(path.Clean(p))
+	osWrapper := osabstraction.RealOS{}
+	if !osWrapper.IsDirectory(root.FullPath()) {
+		exit(exitInvalidArguments, root.FullPath()+" is not a directory")
+	}
+
+	tree := filesystem.Tree{}
+	err := tree.Create(root, osWrapper)
+	if err != nil {
+		exit(exitFailure, errors.New("could not read the tree of "+root.FullPath()+": "+err.Error()))
+	}
+	opts.DirStats = true
+	err = tree.Export(os.Stdout, format, opts)
+	if err != nil {
+		exit(exitFailure, err)
+	}
+}